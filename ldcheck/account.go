@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// warn about passwords and accounts expiring within
+const expiryWarning = 14 * 24 * time.Hour
+
+// AccountStatus is what the directory tells about the validity of the account and its password.
+type AccountStatus struct {
+	PasswordPolicy *PasswordPolicy `json:"password_policy,omitempty"`
+	AD             *ADAccount      `json:"active_directory,omitempty"`
+	Warnings       []string        `json:"warnings,omitempty"`
+}
+
+// PasswordPolicy is the bind response control of draft-behera-ldap-password-policy.
+type PasswordPolicy struct {
+	ExpiresIn   *int64 `json:"expires_in_seconds,omitempty"`
+	GraceLogins *int64 `json:"grace_logins_remaining,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func NewPasswordPolicy(c *ldap.ControlBeheraPasswordPolicy) *PasswordPolicy {
+	pp := new(PasswordPolicy)
+	if c.Expire >= 0 {
+		pp.ExpiresIn = &c.Expire
+	}
+	if c.Grace >= 0 {
+		pp.GraceLogins = &c.Grace
+	}
+	if c.Error >= 0 {
+		pp.Error = c.ErrorString
+	}
+	return pp
+}
+
+// Active Directory account attributes, read with the profile
+var adAccountAttributes = []string{"userAccountControl", "msDS-User-Account-Control-Computed",
+	"pwdLastSet", "msDS-UserPasswordExpiryTimeComputed", "lockoutTime", "accountExpires"}
+
+// userAccountControl flags
+var uacFlags = []struct {
+	Bit  int64
+	Name string
+}{
+	{0x0002, "ACCOUNTDISABLE"},
+	{0x0010, "LOCKOUT"},
+	{0x0020, "PASSWD_NOTREQD"},
+	{0x0040, "PASSWD_CANT_CHANGE"},
+	{0x0080, "ENCRYPTED_TEXT_PWD_ALLOWED"},
+	{0x0200, "NORMAL_ACCOUNT"},
+	{0x0800, "INTERDOMAIN_TRUST_ACCOUNT"},
+	{0x1000, "WORKSTATION_TRUST_ACCOUNT"},
+	{0x2000, "SERVER_TRUST_ACCOUNT"},
+	{0x10000, "DONT_EXPIRE_PASSWORD"},
+	{0x40000, "SMARTCARD_REQUIRED"},
+	{0x80000, "TRUSTED_FOR_DELEGATION"},
+	{0x100000, "NOT_DELEGATED"},
+	{0x400000, "DONT_REQ_PREAUTH"},
+	{0x800000, "PASSWORD_EXPIRED"},
+}
+
+// ADAccount decodes the Active Directory account attributes.
+type ADAccount struct {
+	Flags           []string   `json:"flags"`
+	PasswordLastSet *time.Time `json:"password_last_set,omitempty"`
+	MustChange      bool       `json:"must_change_password,omitempty"`
+	PasswordExpires *time.Time `json:"password_expires,omitempty"`
+	LockedSince     *time.Time `json:"locked_since,omitempty"`
+	AccountExpires  *time.Time `json:"account_expires,omitempty"`
+}
+
+func (a *ADAccount) has(flag string) bool {
+	for _, f := range a.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAccount combines the password policy control received on bind with the AD attributes of the entry,
+// warning about anything that will soon prevent the user from logging in.
+// It returns nil when the server gives no account information.
+func CheckAccount(pp *PasswordPolicy, entry *ldap.Entry, now time.Time) *AccountStatus {
+	st := &AccountStatus{PasswordPolicy: pp}
+	warnf := func(format string, args ...any) { st.Warnings = append(st.Warnings, fmt.Sprintf(format, args...)) }
+
+	if pp != nil {
+		if pp.ExpiresIn != nil {
+			warnf("this password expires in %s", approx(time.Duration(*pp.ExpiresIn)*time.Second))
+		}
+		if pp.GraceLogins != nil {
+			warnf("this password has expired, %d grace logins remaining", *pp.GraceLogins)
+		}
+		if pp.Error != "" {
+			warnf("password policy: %s", pp.Error)
+		}
+	}
+
+	if entry != nil && entry.GetEqualFoldAttributeValue("userAccountControl") != "" {
+		st.AD = decodeADAccount(entry)
+		ad := st.AD
+		switch {
+		case ad.has("ACCOUNTDISABLE"):
+			warnf("the account is disabled")
+		case ad.has("LOCKOUT") && ad.LockedSince != nil:
+			warnf("the account is locked since %s", ad.LockedSince.Format(time.RFC1123))
+		case ad.has("LOCKOUT"):
+			warnf("the account is locked")
+		}
+		switch {
+		case ad.MustChange:
+			warnf("the user must change the password at next logon")
+		case ad.has("PASSWORD_EXPIRED"):
+			warnf("this password has expired")
+		case ad.PasswordExpires != nil && ad.PasswordExpires.Sub(now) < expiryWarning:
+			warnf("this password expires in %s, on %s", approx(ad.PasswordExpires.Sub(now)), ad.PasswordExpires.Format(time.RFC1123))
+		}
+		if ad.AccountExpires != nil {
+			if left := ad.AccountExpires.Sub(now); left <= 0 {
+				warnf("the account expired on %s", ad.AccountExpires.Format(time.RFC1123))
+			} else if left < expiryWarning {
+				warnf("the account expires in %s, on %s", approx(left), ad.AccountExpires.Format(time.RFC1123))
+			}
+		}
+	}
+
+	if st.PasswordPolicy == nil && st.AD == nil {
+		return nil
+	}
+	return st
+}
+
+func decodeADAccount(entry *ldap.Entry) *ADAccount {
+	ad := new(ADAccount)
+	uac, _ := strconv.ParseInt(entry.GetEqualFoldAttributeValue("userAccountControl"), 10, 64)
+	computed, _ := strconv.ParseInt(entry.GetEqualFoldAttributeValue("msDS-User-Account-Control-Computed"), 10, 64)
+	for _, f := range uacFlags {
+		if (uac|computed)&f.Bit != 0 {
+			ad.Flags = append(ad.Flags, f.Name)
+		}
+	}
+
+	filetime := func(attr string) (int64, bool) {
+		v, err := strconv.ParseInt(entry.GetEqualFoldAttributeValue(attr), 10, 64)
+		return v, err == nil
+	}
+	if v, ok := filetime("pwdLastSet"); ok {
+		if v == 0 {
+			ad.MustChange = true
+		} else {
+			ad.PasswordLastSet = fromFiletime(v)
+		}
+	}
+	if v, ok := filetime("msDS-UserPasswordExpiryTimeComputed"); ok && !ad.has("DONT_EXPIRE_PASSWORD") {
+		ad.PasswordExpires = fromFiletime(v)
+	}
+	if v, ok := filetime("lockoutTime"); ok {
+		// lockoutTime stays set after the lockout duration, until the next successful login
+		ad.LockedSince = fromFiletime(v)
+	}
+	if v, ok := filetime("accountExpires"); ok {
+		ad.AccountExpires = fromFiletime(v)
+	}
+	return ad
+}
+
+// fromFiletime converts Windows FILETIME, 100ns intervals since 1601, where 0 and the maximum mean never.
+func fromFiletime(v int64) *time.Time {
+	if v <= 0 || v == math.MaxInt64 {
+		return nil
+	}
+	const epochDelta = 116444736000000000 // 1601-01-01 to 1970-01-01
+	t := time.Unix(0, (v-epochDelta)*100).UTC()
+	return &t
+}
+
+// approx rounds d to a human duration, such as 3 days.
+func approx(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d >= 2*time.Minute:
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestCheckAccount(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	filetime := func(t time.Time) string { return strconv.FormatInt(t.UnixNano()/100+116444736000000000, 10) }
+
+	entry := ldap.NewEntry("cn=jdoe,dc=example,dc=com", map[string][]string{
+		"userAccountControl":                  {"512"},
+		"pwdLastSet":                          {filetime(now.AddDate(0, 0, -87))},
+		"msDS-UserPasswordExpiryTimeComputed": {filetime(now.AddDate(0, 0, 3))},
+		"lockoutTime":                         {"0"},
+		"accountExpires":                      {"9223372036854775807"},
+	})
+	st := CheckAccount(nil, entry, now)
+	if st == nil || st.AD == nil {
+		t.Fatal("AD account not decoded")
+	}
+	if len(st.AD.Flags) != 1 || st.AD.Flags[0] != "NORMAL_ACCOUNT" {
+		t.Errorf("flags = %v", st.AD.Flags)
+	}
+	if st.AD.LockedSince != nil || st.AD.AccountExpires != nil {
+		t.Errorf("lockout %v, expiry %v: want never", st.AD.LockedSince, st.AD.AccountExpires)
+	}
+	if len(st.Warnings) != 1 || !strings.HasPrefix(st.Warnings[0], "this password expires in 3 days") {
+		t.Errorf("warnings = %q", st.Warnings)
+	}
+
+	grace := int64(2)
+	st = CheckAccount(&PasswordPolicy{GraceLogins: &grace}, nil, now)
+	if st == nil || len(st.Warnings) != 1 || !strings.Contains(st.Warnings[0], "2 grace logins") {
+		t.Errorf("password policy warnings = %+v", st)
+	}
+
+	if st := CheckAccount(nil, ldap.NewEntry("uid=jdoe,dc=example,dc=com", nil), now); st != nil {
+		t.Errorf("got account status for a non-AD entry: %+v", st)
+	}
+}
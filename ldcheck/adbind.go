@@ -0,0 +1,66 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ADBindError explains why Active Directory or Samba rejected a bind.
+// AD encodes the reason in the diagnostic message of the Invalid Credentials result, e.g.
+//
+//	80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 775, v4563
+type ADBindError struct {
+	Code        string `json:"code"`
+	Reason      string `json:"reason"`
+	Explanation string `json:"explanation"`
+	// Unusable is set when the password was accepted, or no longer matters, but the account cannot log in.
+	Unusable bool `json:"-"`
+}
+
+var adBindErrors = []ADBindError{
+	{"525", "user not found", "no account matches the bind name, check bind_pattern or the user name", false},
+	{"52e", "wrong password", "the account exists but the password is wrong (AD also answers this for unknown user names)", false},
+	{"530", "logon hours restriction", "the password is right, but the account may not log in at this time of day (logonHours)", true},
+	{"531", "workstation restriction", "the password is right, but the account may only log in from listed workstations (userWorkstations)", true},
+	{"532", "password expired", "the password is right but has expired, the user must change it", true},
+	{"533", "account disabled", "the account is disabled, an administrator must enable it", true},
+	{"701", "account expired", "the account has expired (accountExpires), an administrator must extend it", true},
+	{"773", "must reset password", "the password is right, but the user must change it at next logon (pwdLastSet is 0)", true},
+	{"775", "account locked", "the account is locked after too many failed logins, wait for the lockout duration or have it unlocked", true},
+}
+
+// NTSTATUS names used by older Samba releases instead of AD data codes
+var sambaStatus = map[string]string{
+	"NT_STATUS_NO_SUCH_USER":         "525",
+	"NT_STATUS_WRONG_PASSWORD":       "52e",
+	"NT_STATUS_LOGON_FAILURE":        "52e",
+	"NT_STATUS_INVALID_LOGON_HOURS":  "530",
+	"NT_STATUS_INVALID_WORKSTATION":  "531",
+	"NT_STATUS_PASSWORD_EXPIRED":     "532",
+	"NT_STATUS_ACCOUNT_DISABLED":     "533",
+	"NT_STATUS_ACCOUNT_EXPIRED":      "701",
+	"NT_STATUS_PASSWORD_MUST_CHANGE": "773",
+	"NT_STATUS_ACCOUNT_LOCKED_OUT":   "775",
+}
+
+var (
+	adDataCode = regexp.MustCompile(`(?i)\bdata ([0-9a-f]+)\b`)
+	ntStatus   = regexp.MustCompile(`NT_STATUS_[A-Z_]+`)
+)
+
+// DecodeADBindError recognizes the AD and Samba diagnostic message of a failed bind.
+// It returns nil for messages from other servers and for unknown codes.
+func DecodeADBindError(msg string) *ADBindError {
+	var code string
+	if m := adDataCode.FindStringSubmatch(msg); m != nil {
+		code = strings.ToLower(m[1])
+	} else if m := ntStatus.FindString(msg); m != "" {
+		code = sambaStatus[m]
+	}
+	for _, e := range adBindErrors {
+		if e.Code == code {
+			return &e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestDecodeADBindError(t *testing.T) {
+	for _, c := range []struct{ msg, code string }{
+		{"80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 52e, v4563", "52e"},
+		{"80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 775, v2580", "775"},
+		{"Simple Bind Failed: NT_STATUS_ACCOUNT_DISABLED", "533"},
+		{"80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 999, v4563", ""},
+		{"invalid credentials", ""},
+	} {
+		d := DecodeADBindError(c.msg)
+		switch {
+		case c.code == "" && d != nil:
+			t.Errorf("%q: decoded as %s", c.msg, d.Reason)
+		case c.code != "" && (d == nil || d.Code != c.code):
+			t.Errorf("%q: got %+v, want code %s", c.msg, d, c.code)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// AttributeMap tells which attributes Security Hub builds the user profile from.
+type AttributeMap struct {
+	DisplayName string `toml:"display_name"` // attribute, or several joined with +, e.g. givenName+sn
+	Email       string `toml:"email"`
+	UniqueID    string `toml:"unique_id"`
+	Groups      string `toml:"groups"` // optional, may be multi-valued
+}
+
+type mappedField struct {
+	Field    string
+	Attrs    []string
+	Single   bool
+	Optional bool
+}
+
+func (m AttributeMap) fields() []mappedField {
+	if m.DisplayName == "" {
+		m.DisplayName = "displayName"
+	}
+	if m.Email == "" {
+		m.Email = "mail"
+	}
+
+	fs := []mappedField{
+		{Field: "display_name", Attrs: strings.Split(m.DisplayName, "+"), Single: true},
+		{Field: "email", Attrs: []string{m.Email}, Single: true},
+	}
+	if m.UniqueID != "" {
+		fs = append(fs, mappedField{Field: "unique_id", Attrs: []string{m.UniqueID}, Single: true})
+	}
+	if m.Groups != "" {
+		fs = append(fs, mappedField{Field: "groups", Attrs: []string{m.Groups}, Optional: true})
+	}
+	return fs
+}
+
+// Names lists the attributes to request from the server.
+func (m AttributeMap) Names() []string {
+	var names []string
+	for _, f := range m.fields() {
+		for _, a := range f.Attrs {
+			names = append(names, strings.TrimSpace(a))
+		}
+	}
+	return names
+}
+
+// AttributeVerdict tells whether a profile field can be built from the user entry.
+type AttributeVerdict struct {
+	Field     string   `json:"field"`
+	Attribute string   `json:"attribute"`
+	Values    []string `json:"values,omitempty"`
+	OK        bool     `json:"ok"`
+	Verdict   string   `json:"verdict"`
+}
+
+// CheckAttributes validates each mapped attribute exists, is non-empty, and is single-valued where required.
+func CheckAttributes(m AttributeMap, entry *ldap.Entry) []AttributeVerdict {
+	var vs []AttributeVerdict
+	for _, f := range m.fields() {
+		for _, a := range f.Attrs {
+			a = strings.TrimSpace(a)
+			v := AttributeVerdict{Field: f.Field, Attribute: a}
+			for _, raw := range entry.GetEqualFoldRawAttributeValues(a) {
+				v.Values = append(v.Values, printable(raw))
+			}
+
+			nonempty := 0
+			for _, x := range v.Values {
+				if strings.TrimSpace(x) != "" {
+					nonempty++
+				}
+			}
+			switch {
+			case len(v.Values) == 0 && f.Optional:
+				v.OK, v.Verdict = true, "absent, user has no value"
+			case len(v.Values) == 0:
+				v.Verdict = "missing: attribute not set or not readable by the user"
+			case nonempty == 0:
+				v.Verdict = "empty value"
+			case f.Single && len(v.Values) > 1:
+				v.Verdict = fmt.Sprintf("multi-valued (%d values), a single value is required", len(v.Values))
+			default:
+				v.OK, v.Verdict = true, "ok"
+			}
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// printable shows binary values, such as objectGUID, in hexadecimal.
+func printable(raw []byte) string {
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+	return "0x" + hex.EncodeToString(raw)
+}
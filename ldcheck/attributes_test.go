@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestCheckAttributes(t *testing.T) {
+	entry := ldap.NewEntry("uid=jdoe,dc=example,dc=com", map[string][]string{
+		"givenName":  {"John"},
+		"sn":         {"Doe"},
+		"mail":       {"jdoe@example.com", "john.doe@example.com"},
+		"uid":        {" "},
+		"objectGUID": {"\xff\x01"},
+	})
+	m := AttributeMap{DisplayName: "givenName+sn", Email: "mail", UniqueID: "uid", Groups: "memberOf"}
+
+	want := map[string]bool{"givenName": true, "sn": true, "mail": false, "uid": false, "memberOf": true}
+	vs := CheckAttributes(m, entry)
+	if len(vs) != len(want) {
+		t.Fatalf("got %d verdicts, want %d: %v", len(vs), len(want), vs)
+	}
+	for _, v := range vs {
+		if v.OK != want[v.Attribute] {
+			t.Errorf("%s (%s): ok = %t, want %t (%s)", v.Field, v.Attribute, v.OK, want[v.Attribute], v.Verdict)
+		}
+	}
+
+	m.UniqueID = "objectGUID"
+	for _, v := range CheckAttributes(m, entry) {
+		if v.Field == "unique_id" && (len(v.Values) != 1 || v.Values[0] != "0xff01") {
+			t.Errorf("binary value shown as %v", v.Values)
+		}
+	}
+}
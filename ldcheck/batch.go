@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Credential is one row of a batch file.
+type Credential struct {
+	UserName string
+	Password string
+}
+
+// ReadCredentials parses a CSV of user name and password, with an optional header line.
+// Rows without a password are completed with prompt.
+func ReadCredentials(r io.Reader, prompt func(name string) (string, error)) ([]Credential, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	var creds []Credential
+	for i := 0; ; i++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return creds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 && strings.EqualFold(rec[0], "username") {
+			continue
+		}
+
+		switch len(rec) {
+		case 1:
+			pw, err := prompt(rec[0])
+			if err != nil {
+				return nil, err
+			}
+			creds = append(creds, Credential{UserName: rec[0], Password: pw})
+		case 2:
+			creds = append(creds, Credential{UserName: rec[0], Password: rec[1]})
+		default:
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: want username[,password], got %d fields", line, len(rec))
+		}
+	}
+}
+
+// CheckBatch checks every credential.
+// With a shared connection, the logins happen one after the other on a single connection;
+// otherwise each user gets its own connection, concurrency of them at a time.
+func CheckBatch(cfg LDAPConfig, creds []Credential, shared bool, concurrency int) []*Result {
+	results := make([]*Result, len(creds))
+
+	if shared {
+		conn := &Result{URL: cfg.ServerURL}
+		ctn, err := Connect(conn, cfg)
+		if err != nil {
+			for i, c := range creds {
+				res := *conn
+				res.UserName = c.UserName
+				results[i] = &res
+			}
+			return results
+		}
+		defer ctn.Close()
+
+		for i, c := range creds {
+			res := &Result{URL: conn.URL, UserName: c.UserName}
+			res.SetConn(ctn)
+			CheckLogin(ctn, cfg, c.UserName, c.Password, res)
+			results[i] = res
+		}
+		return results
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, c := range creds {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, c Credential) {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = TestLoginWithLDAP(cfg, c.UserName, c.Password)
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+// BatchExitCode is ExitOK when all logins succeed, the shared exit code when they all fail the same way,
+// and ExitFailure otherwise.
+func BatchExitCode(results []*Result) int {
+	code := ExitOK
+	for _, res := range results {
+		c := ExitCode(res)
+		switch {
+		case c == ExitOK:
+		case code == ExitOK:
+			code = c
+		case code != c:
+			return ExitFailure
+		}
+	}
+	return code
+}
+
+func WriteBatchText(w io.Writer, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "user\tstatus\texit\tuser DN\treason")
+	failed := 0
+	for _, res := range results {
+		reason := ""
+		if res.Err != nil {
+			failed++
+			reason = res.FailedPhase + ": " + res.Error
+			if res.Bind != nil && res.Bind.Directory != nil {
+				reason = res.FailedPhase + ": " + res.Bind.Directory.Reason
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", res.UserName, res.Status, ExitCode(res), res.UserDN, reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d users checked, %d ok, %d failed\n", len(results), len(results)-failed, failed)
+	return err
+}
+
+func WriteBatchJSON(w io.Writer, results []*Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
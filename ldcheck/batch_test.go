@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckBatch(t *testing.T) {
+	csv := "username,password\njdoe,secret\njdoe,wrong\n# prompted\nlocked\n"
+	creds, err := ReadCredentials(strings.NewReader(csv), func(name string) (string, error) { return "secret", nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 3 || creds[2] != (Credential{"locked", "secret"}) {
+		t.Fatalf("credentials = %v", creds)
+	}
+
+	cfg := LDAPConfig{
+		ServerURL:       startFakeDirectory(t, testDirectory()),
+		BindUserPattern: "uid={{.UserName}},ou=people,dc=example,dc=com",
+	}
+	want := []int{ExitOK, ExitInvalidCredentials, ExitAccountUnusable}
+	for _, shared := range []bool{true, false} {
+		results := CheckBatch(cfg, creds, shared, 2)
+		for i, res := range results {
+			if got := ExitCode(res); got != want[i] {
+				t.Errorf("shared=%t, row %d: exit code %d, want %d (%v)", shared, i, got, want[i], res.Err)
+			}
+		}
+		if got := BatchExitCode(results); got != ExitFailure {
+			t.Errorf("shared=%t: batch exit code %d, want %d", shared, got, ExitFailure)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// BenchOptions control the load generated by Bench.
+type BenchOptions struct {
+	Conns     int           // concurrent connections
+	Duration  time.Duration // used when Count is zero
+	Count     int           // total binds across connections, the run stops after as many failed connections
+	Search    bool          // read the user attributes after each bind
+	Reconnect bool          // dial a new connection for every bind
+	// MaxFailures stops the run once that many binds failed, to avoid locking the account.
+	// Binds are sent one at a time until one succeeds, so a wrong password never goes past it.
+	MaxFailures int
+}
+
+// BenchStats holds the measurements of a Bench run.
+type BenchStats struct {
+	Elapsed      time.Duration
+	Binds        int
+	Failures     int
+	DialFailures int
+	Stopped      string // reason the run ended early
+
+	Errors    map[string]int // by LDAP result code
+	Latencies map[string][]time.Duration
+}
+
+var benchPhases = []string{"dial", "tls", PhaseBind, PhaseRead}
+
+// longest wait between failed connections
+const maxBenchBackoff = 30 * time.Second
+
+// Bench repeatedly binds as the user with N concurrent connections.
+func Bench(d *Dialer, cfg LDAPConfig, name, userdn, pass string, opts BenchOptions) *BenchStats {
+	stats := &BenchStats{Errors: make(map[string]int), Latencies: make(map[string][]time.Duration)}
+	var (
+		mx       sync.Mutex
+		started  atomic.Int64
+		failures atomic.Int64
+		dialErrs atomic.Int64
+		stop     atomic.Bool
+		verified atomic.Bool // a bind succeeded, the password is right
+		single   sync.Mutex  // one bind in flight until then
+	)
+	record := func(phase string, d time.Duration, err error) {
+		mx.Lock()
+		defer mx.Unlock()
+		if d > 0 {
+			stats.Latencies[phase] = append(stats.Latencies[phase], d)
+		}
+		if err != nil {
+			stats.Errors[phase+": "+resultCodeName(err)]++
+		}
+	}
+	halt := func(reason string) {
+		mx.Lock()
+		if stats.Stopped == "" {
+			stats.Stopped = reason
+		}
+		mx.Unlock()
+		stop.Store(true)
+	}
+
+	deadline := time.Now().Add(opts.Duration)
+	more := func() bool {
+		if stop.Load() {
+			return false
+		}
+		if opts.Count > 0 {
+			return started.Load() < int64(opts.Count)
+		}
+		return time.Now().Before(deadline)
+	}
+	// only binds use up the count, claimed right before sending them
+	claim := func() bool {
+		return opts.Count == 0 || started.Add(1) <= int64(opts.Count)
+	}
+	pause := func(backoff time.Duration) {
+		if left := time.Until(deadline); opts.Count == 0 && left < backoff {
+			backoff = left
+		}
+		time.Sleep(backoff)
+	}
+
+	dial := func() *Conn {
+		ctn, err := d.DialURL(cfg.ServerURL)
+		if err != nil {
+			record("dial", 0, err)
+			dialErrs.Add(1)
+			return nil
+		}
+		record("dial", ctn.DialTime, nil)
+		record("tls", ctn.TLSTime, nil)
+		return ctn
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var ctn *Conn
+			backoff := d.Backoff
+			defer func() {
+				if ctn != nil {
+					ctn.Close()
+				}
+			}()
+
+			for more() {
+				if ctn == nil || opts.Reconnect {
+					if ctn != nil {
+						ctn.Close()
+					}
+					if ctn = dial(); ctn == nil {
+						if n := dialErrs.Load(); opts.Count > 0 && n >= int64(opts.Count) {
+							halt(fmt.Sprintf("%d failed connections", n))
+						}
+						pause(backoff)
+						if backoff *= 2; backoff > maxBenchBackoff {
+							backoff = maxBenchBackoff
+						}
+						continue
+					}
+					backoff = d.Backoff
+				}
+
+				gated := !verified.Load()
+				if gated {
+					single.Lock()
+				}
+				if stop.Load() || !claim() {
+					if gated {
+						single.Unlock()
+					}
+					return
+				}
+				t := time.Now()
+				_, err := BindUser(ctn, cfg, name, userdn, pass)
+				record(PhaseBind, time.Since(t), err)
+				mx.Lock()
+				stats.Binds++
+				mx.Unlock()
+				if err == nil {
+					verified.Store(true)
+				} else if n := failures.Add(1); opts.MaxFailures > 0 && n >= int64(opts.MaxFailures) {
+					halt(fmt.Sprintf("%d failed binds, refusing to risk locking the account", n))
+				}
+				if gated {
+					single.Unlock()
+				}
+				if err != nil {
+					if ctn.IsClosing() {
+						ctn.Close()
+						ctn = nil
+					}
+					continue
+				}
+
+				if opts.Search {
+					t = time.Now()
+					_, err := ctn.Search(ldap.NewSearchRequest(userdn,
+						ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+						"(&)", cfg.Attributes.Names(), nil))
+					record(PhaseRead, time.Since(t), err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats.Elapsed = time.Since(start)
+	stats.Failures = int(failures.Load())
+	stats.DialFailures = int(dialErrs.Load())
+	return stats
+}
+
+func resultCodeName(err error) string {
+	var lerr *ldap.Error
+	if errors.As(err, &lerr) {
+		name := fmt.Sprintf("%d %s", lerr.ResultCode, ldap.LDAPResultCodeMap[lerr.ResultCode])
+		if lerr.Err != nil {
+			if d := DecodeADBindError(lerr.Err.Error()); d != nil {
+				name += fmt.Sprintf(" (data %s, %s)", d.Code, d.Reason)
+			}
+		}
+		return name
+	}
+	return err.Error()
+}
+
+type benchSummary struct {
+	ElapsedMillis float64                       `json:"elapsed_ms"`
+	Binds         int                           `json:"binds"`
+	Failures      int                           `json:"failures"`
+	DialFailures  int                           `json:"dial_failures,omitempty"`
+	Throughput    float64                       `json:"binds_per_second"`
+	Stopped       string                        `json:"stopped,omitempty"`
+	Errors        map[string]int                `json:"errors,omitempty"`
+	Phases        map[string]map[string]float64 `json:"latencies_ms"`
+}
+
+func (s *BenchStats) WriteJSON(w io.Writer) error {
+	sum := benchSummary{
+		ElapsedMillis: ms(s.Elapsed),
+		Binds:         s.Binds,
+		Failures:      s.Failures,
+		DialFailures:  s.DialFailures,
+		Throughput:    float64(s.Binds) / s.Elapsed.Seconds(),
+		Stopped:       s.Stopped,
+		Errors:        s.Errors,
+		Phases:        make(map[string]map[string]float64),
+	}
+	for p, l := range s.Latencies {
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		sum.Phases[p] = map[string]float64{
+			"count": float64(len(l)),
+			"min":   ms(l[0]),
+			"p50":   ms(percentile(l, 50)),
+			"p90":   ms(percentile(l, 90)),
+			"p99":   ms(percentile(l, 99)),
+			"max":   ms(l[len(l)-1]),
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sum)
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+// histogram bucket upper bounds
+var benchBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, math.MaxInt64,
+}
+
+func (s *BenchStats) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "%d binds in %s: %.1f binds/s, %d failed (%.1f%%)\n",
+		s.Binds, s.Elapsed.Round(time.Millisecond), float64(s.Binds)/s.Elapsed.Seconds(),
+		s.Failures, 100*float64(s.Failures)/math.Max(1, float64(s.Binds)))
+	if s.DialFailures > 0 {
+		fmt.Fprintf(w, "%d connections failed\n", s.DialFailures)
+	}
+	if s.Stopped != "" {
+		fmt.Fprintln(w, "STOPPED:", s.Stopped)
+	}
+
+	if len(s.Errors) > 0 {
+		fmt.Fprintln(w, "\nerrors:")
+		keys := make([]string, 0, len(s.Errors))
+		for k := range s.Errors {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "  %6d  %s\n", s.Errors[k], k)
+		}
+	}
+
+	fmt.Fprintln(w, "\nlatencies:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "phase\tcount\tmin\tp50\tp90\tp99\tmax\t")
+	for _, p := range benchPhases {
+		l := s.Latencies[p]
+		if len(l) == 0 {
+			continue
+		}
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t\n", p, len(l),
+			round(l[0]), round(percentile(l, 50)), round(percentile(l, 90)), round(percentile(l, 99)), round(l[len(l)-1]))
+	}
+	tw.Flush()
+
+	for _, p := range benchPhases {
+		l := s.Latencies[p]
+		if len(l) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s histogram:\n", p)
+		counts := make([]int, len(benchBuckets))
+		for _, d := range l {
+			counts[sort.Search(len(benchBuckets), func(i int) bool { return d <= benchBuckets[i] })]++
+		}
+		for i, c := range counts {
+			label := "<= " + benchBuckets[i].String()
+			if benchBuckets[i] == math.MaxInt64 {
+				label = "> " + benchBuckets[i-1].String()
+			}
+			fmt.Fprintf(w, "  %9s %6d %s\n", label, c, strings.Repeat("#", int(math.Ceil(50*float64(c)/float64(len(l))))))
+		}
+	}
+	return nil
+}
+
+// percentile expects sorted latencies.
+func percentile(l []time.Duration, p int) time.Duration {
+	return l[(len(l)-1)*p/100]
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d > time.Second:
+		return d.Round(time.Millisecond)
+	case d > time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+	dir := testDirectory()
+	cfg := LDAPConfig{ServerURL: startFakeDirectory(t, dir)}
+	const userdn = "uid=jdoe,ou=people,dc=example,dc=com"
+
+	stats := Bench(&Dialer{}, cfg, "jdoe", userdn, "secret", BenchOptions{Conns: 3, Count: 20, Search: true, MaxFailures: 3})
+	if stats.Binds != 20 || stats.Failures != 0 || len(stats.Latencies[PhaseRead]) != 20 {
+		t.Errorf("got %d binds, %d failures, %d reads", stats.Binds, stats.Failures, len(stats.Latencies[PhaseRead]))
+	}
+
+	for _, conns := range []int{1, 8} {
+		dir.binds = 0
+		stats = Bench(&Dialer{}, cfg, "jdoe", userdn, "wrong", BenchOptions{Conns: conns, Count: 20, MaxFailures: 3})
+		if stats.Failures != 3 || stats.Stopped == "" || dir.binds != 3 {
+			t.Errorf("safeguard with %d connections: got %d failures, %d binds on the server, stopped: %q", conns, stats.Failures, dir.binds, stats.Stopped)
+		}
+	}
+}
+
+func TestBenchUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := LDAPConfig{ServerURL: "ldap://" + l.Addr().String()}
+	l.Close()
+
+	d := &Dialer{DialTimeout: time.Second, Backoff: 20 * time.Millisecond}
+	stats := Bench(d, cfg, "jdoe", "uid=jdoe", "secret", BenchOptions{Conns: 2, Duration: 100 * time.Millisecond, MaxFailures: 3})
+	if stats.Binds != 0 || stats.DialFailures == 0 || stats.DialFailures > 10 {
+		t.Errorf("got %d binds, %d failed connections, want a few backed off failures", stats.Binds, stats.DialFailures)
+	}
+
+	// the backoff does not outlast the run
+	d.Backoff = time.Minute
+	stats = Bench(d, cfg, "jdoe", "uid=jdoe", "secret", BenchOptions{Conns: 2, Duration: 100 * time.Millisecond, MaxFailures: 3})
+	if stats.Elapsed > time.Second {
+		t.Errorf("ran for %s, past the 100ms duration", stats.Elapsed)
+	}
+
+	// failed connections do not use up the count, but end the run
+	d.Backoff = time.Millisecond
+	stats = Bench(d, cfg, "jdoe", "uid=jdoe", "secret", BenchOptions{Conns: 2, Count: 5, MaxFailures: 3})
+	if stats.Binds != 0 || stats.DialFailures < 5 || stats.Stopped == "" {
+		t.Errorf("got %d binds, %d failed connections, stopped %q", stats.Binds, stats.DialFailures, stats.Stopped)
+	}
+}
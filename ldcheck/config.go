@@ -0,0 +1,69 @@
+package main
+
+import "time"
+
+// Config is the layout of the configuration file.
+type Config struct {
+	LDAP LDAPConfig
+}
+
+type LDAPConfig struct {
+	ServerURL       string   `toml:"server_url"`
+	ServerURLs      []string `toml:"server_urls"` // tried in order after server_url, until one answers
+	Domain          string   `toml:"domain"`      // servers found in the _ldap._tcp SRV records, tried last
+	BindUserPattern string   `toml:"bind_pattern"`
+	BindPatterns    []string `toml:"bind_patterns"` // tried in order after bind_pattern, while the entry does not exist
+	StartTLS        string   `toml:"starttls"`      // off, optional or required; only for ldap:// URLs
+
+	// search-then-bind mode, used when UserFilter is set
+	BindDN         string `toml:"bind_dn"`
+	BindPassword   string `toml:"bind_password"`
+	UserSearchBase string `toml:"user_search_base"`
+	UserFilter     string `toml:"user_filter"`
+
+	BindMethod string `toml:"bind_method"` // simple (default), external, digest-md5 or ntlm
+	NTLMDomain string `toml:"ntlm_domain"` // for user names without a DOMAIN\ prefix
+	NTLMHash   bool   `toml:"ntlm_hash"`   // the password is the hexadecimal NT hash
+
+	Timeouts TimeoutConfig `toml:"timeouts"`
+	Retry    RetryConfig   `toml:"retry"`
+
+	TLS        TLSConfig    `toml:"tls"`
+	Attributes AttributeMap `toml:"attributes"`
+	Groups     GroupConfig  `toml:"groups"`
+	Roles      RoleConfig   `toml:"roles"`
+}
+
+// Servers lists the server URLs in the order they are tried, domain as an SRV URL.
+func (c LDAPConfig) Servers() []string {
+	var urls []string
+	if c.ServerURL != "" {
+		urls = append(urls, c.ServerURL)
+	}
+	urls = append(urls, c.ServerURLs...)
+	if c.Domain != "" {
+		urls = append(urls, "ldap+srv://"+c.Domain)
+	}
+	return urls
+}
+
+// Patterns lists the bind patterns in the order they are tried.
+func (c LDAPConfig) Patterns() []string {
+	var ps []string
+	if c.BindUserPattern != "" {
+		ps = append(ps, c.BindUserPattern)
+	}
+	return append(ps, c.BindPatterns...)
+}
+
+// TimeoutConfig bounds each phase of a connection, zero values use the defaults.
+type TimeoutConfig struct {
+	Dial      time.Duration `toml:"dial"`
+	TLS       time.Duration `toml:"tls"`       // ldaps or StartTLS handshake
+	Operation time.Duration `toml:"operation"` // each LDAP request
+}
+
+type RetryConfig struct {
+	Attempts int           `toml:"attempts"` // per server, 1 when unset
+	Backoff  time.Duration `toml:"backoff"`  // before the second attempt, doubled for each of the following
+}
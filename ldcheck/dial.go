@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// StartTLS policies for ldap:// URLs
+const (
+	StartTLSOff      = "off"
+	StartTLSOptional = "optional"
+	StartTLSRequired = "required"
+)
+
+// Default timeouts
+const (
+	DefaultDialTimeout      = 10 * time.Second
+	DefaultTLSTimeout       = 10 * time.Second
+	DefaultOperationTimeout = 30 * time.Second
+	DefaultBackoff          = time.Second
+)
+
+// Dialer opens LDAP connections according to the transport settings of the configuration.
+type Dialer struct {
+	StartTLS string
+	TLS      *tls.Config // cloned for each connection
+
+	DialTimeout      time.Duration
+	TLSTimeout       time.Duration
+	OperationTimeout time.Duration
+
+	Attempts int // per server
+	Backoff  time.Duration
+
+	Resolver Resolver // for SRV discovery
+}
+
+func NewDialer(cfg LDAPConfig) (*Dialer, error) {
+	tc, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, err
+	}
+	or := func(d, def time.Duration) time.Duration {
+		if d > 0 {
+			return d
+		}
+		return def
+	}
+	d := &Dialer{
+		StartTLS:         cfg.StartTLS,
+		TLS:              tc,
+		DialTimeout:      or(cfg.Timeouts.Dial, DefaultDialTimeout),
+		TLSTimeout:       or(cfg.Timeouts.TLS, DefaultTLSTimeout),
+		OperationTimeout: or(cfg.Timeouts.Operation, DefaultOperationTimeout),
+		Attempts:         cfg.Retry.Attempts,
+		Backoff:          or(cfg.Retry.Backoff, DefaultBackoff),
+		Resolver:         net.DefaultResolver,
+	}
+	if d.Attempts < 1 {
+		d.Attempts = 1
+	}
+	return d, nil
+}
+
+// Conn is an LDAP connection along with how it was secured.
+type Conn struct {
+	*ldap.Conn
+	Endpoint Endpoint
+
+	// Upgraded is set when the connection was upgraded with StartTLS.
+	Upgraded bool
+	// StartTLSErr holds the reason an optional StartTLS upgrade did not happen.
+	StartTLSErr error
+
+	DialTime time.Duration
+	TLSTime  time.Duration // handshake, either ldaps or StartTLS
+
+	raw net.Conn // under TLS, to bound the StartTLS handshake
+}
+
+// Active Directory global catalog ports
+const (
+	DefaultGCPort  = "3268"
+	DefaultGCSPort = "3269"
+)
+
+// Endpoint is a server URL resolved to the address to dial.
+type Endpoint struct {
+	Scheme  string // ldapi, ldap or ldaps, global catalog URLs map to the last two
+	Network string // tcp or unix
+	Addr    string
+	Host    string // expected in the server certificate
+	GC      bool   // Active Directory global catalog
+}
+
+func ParseURL(addr string) (Endpoint, error) {
+	lurl, err := url.Parse(addr)
+	if err != nil {
+		return Endpoint{}, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+
+	host, port, err := net.SplitHostPort(lurl.Host)
+	if err != nil {
+		// we asume that error is due to missing port
+		host = lurl.Host
+		port = ""
+	}
+
+	gc := false
+	switch lurl.Scheme {
+	case "gc":
+		lurl.Scheme, gc = "ldap", true
+		if port == "" {
+			port = DefaultGCPort
+		}
+	case "gcs":
+		lurl.Scheme, gc = "ldaps", true
+		if port == "" {
+			port = DefaultGCSPort
+		}
+	}
+
+	switch lurl.Scheme {
+	case "ldapi":
+		if lurl.Path == "" || lurl.Path == "/" {
+			lurl.Path = "/var/run/slapd/ldapi"
+		}
+		return Endpoint{Scheme: lurl.Scheme, Network: "unix", Addr: lurl.Path}, nil
+	case "ldap":
+		if port == "" {
+			port = ldap.DefaultLdapPort
+		}
+	case "ldaps":
+		if port == "" {
+			port = ldap.DefaultLdapsPort
+		}
+	default:
+		return Endpoint{}, ldap.NewError(ldap.ErrorNetwork, fmt.Errorf("Unknown scheme '%s'", lurl.Scheme))
+	}
+
+	return Endpoint{Scheme: lurl.Scheme, Network: "tcp", Addr: net.JoinHostPort(host, port), Host: host, GC: gc}, nil
+}
+
+func (d *Dialer) DialURL(addr string) (*Conn, error) {
+	ep, err := ParseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch d.StartTLS {
+	case "", StartTLSOff, StartTLSOptional, StartTLSRequired:
+	default:
+		return nil, fmt.Errorf("invalid starttls value %q: want %s, %s or %s", d.StartTLS, StartTLSOff, StartTLSOptional, StartTLSRequired)
+	}
+
+	ctn, err := d.dial(ep)
+	if err != nil || ep.Scheme != "ldap" || d.StartTLS == "" || d.StartTLS == StartTLSOff {
+		return ctn, err
+	}
+
+	start := time.Now()
+	ctn.raw.SetDeadline(deadline(d.TLSTimeout))
+	tlsErr := ctn.StartTLS(d.tlsConfig(ep.Host))
+	ctn.raw.SetDeadline(time.Time{})
+	ctn.TLSTime = time.Since(start)
+	switch {
+	case tlsErr == nil:
+		ctn.Upgraded = true
+		return ctn, nil
+	case d.StartTLS == StartTLSRequired:
+		ctn.Close()
+		return nil, fmt.Errorf("StartTLS required but failed: %w", tlsErr)
+	case !refusedStartTLS(tlsErr):
+		// the server agreed, a failed handshake is an attack as likely as a misconfiguration
+		ctn.Close()
+		return nil, fmt.Errorf("StartTLS accepted but failed, not falling back to cleartext: %w", tlsErr)
+	}
+
+	// go-ldap stops reading the connection once StartTLS fails, even when the server merely refused the operation
+	ctn.Close()
+	if ctn, err = d.dial(ep); err != nil {
+		return nil, err
+	}
+	ctn.StartTLSErr = tlsErr
+	return ctn, nil
+}
+
+// refusedStartTLS reports whether the server answered StartTLS with an error result,
+// the only failure after which optional StartTLS falls back to cleartext.
+func refusedStartTLS(err error) bool {
+	var lerr *ldap.Error
+	return errors.As(err, &lerr) && lerr.ResultCode < ldap.ErrorNetwork && !IsTLSError(err)
+}
+
+// dial opens the transport, performing the TLS handshake for ldaps.
+func (d *Dialer) dial(ep Endpoint) (*Conn, error) {
+	start := time.Now()
+	cn, err := net.DialTimeout(ep.Network, ep.Addr, d.DialTimeout)
+	if err != nil {
+		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+	ctn := &Conn{Endpoint: ep, DialTime: time.Since(start), raw: cn}
+
+	if ep.Scheme == "ldaps" {
+		start = time.Now()
+		cn.SetDeadline(deadline(d.TLSTimeout))
+		tlsc := tls.Client(cn, d.tlsConfig(ep.Host))
+		if err := tlsc.Handshake(); err != nil {
+			cn.Close()
+			return nil, ldap.NewError(ldap.ErrorNetwork, err)
+		}
+		cn.SetDeadline(time.Time{})
+		ctn.TLSTime = time.Since(start)
+		cn = tlsc
+	}
+
+	ctn.Conn = ldap.NewConn(cn, ep.Scheme == "ldaps")
+	ctn.Conn.Start()
+	ctn.Conn.SetTimeout(d.OperationTimeout)
+	return ctn, nil
+}
+
+// deadline is when a phase starting now times out, never for a zero timeout.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+func (d *Dialer) tlsConfig(host string) *tls.Config {
+	tc := new(tls.Config)
+	if d.TLS != nil {
+		tc = d.TLS.Clone()
+	}
+	tc.ServerName = host
+	if tc.MinVersion == 0 {
+		tc.MinVersion = tls.VersionTLS12 // default for clients
+	}
+	return tc
+}
+
+// ServerAttempt reports how connecting to one of the configured servers went.
+type ServerAttempt struct {
+	URL      string  `json:"url"`
+	SRV      string  `json:"srv,omitempty"` // the record the server was discovered with
+	Attempts int     `json:"attempts"`
+	Millis   float64 `json:"ms"`              // connection latency, or time spent before giving up
+	Error    string  `json:"error,omitempty"` // why the server was skipped
+}
+
+// DialServers connects to the first server that answers, retrying each with backoff.
+// SRV URLs are expanded into the servers they designate.
+// Certificate and other TLS failures are not retried, they would fail the same way.
+func (d *Dialer) DialServers(urls []string) (*Conn, []ServerAttempt, error) {
+	if len(urls) == 0 {
+		return nil, nil, errors.New("no server configured: set server_url, server_urls or domain")
+	}
+
+	var tried []ServerAttempt
+	var err error
+	for _, at := range d.Discover(urls) {
+		if at.Error != "" {
+			err = errors.New(at.Error)
+			tried = append(tried, at)
+			continue
+		}
+
+		start := time.Now()
+		for at.Attempts < d.Attempts {
+			if at.Attempts > 0 {
+				time.Sleep(d.Backoff << (at.Attempts - 1))
+			}
+			at.Attempts++
+			var ctn *Conn
+			if ctn, err = d.DialURL(at.URL); err == nil {
+				at.Millis = ms(ctn.DialTime + ctn.TLSTime)
+				return ctn, append(tried, at), nil
+			}
+			if IsTLSError(err) {
+				break
+			}
+		}
+		at.Millis, at.Error = ms(time.Since(start)), err.Error()
+		tried = append(tried, at)
+	}
+	return nil, tried, err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+// The fake directory refuses StartTLS with a protocol error.
+func TestStartTLS(t *testing.T) {
+	url := startFakeDirectory(t, testDirectory())
+	for _, c := range []struct {
+		policy  string
+		dialErr bool
+		refused bool
+	}{
+		{"", false, false},
+		{StartTLSOff, false, false},
+		{StartTLSOptional, false, true},
+		{StartTLSRequired, true, false},
+	} {
+		t.Run(c.policy, func(t *testing.T) {
+			d := &Dialer{StartTLS: c.policy, DialTimeout: time.Second, TLSTimeout: time.Second, OperationTimeout: 2 * time.Second}
+			ctn, err := d.DialURL(url)
+			if c.dialErr {
+				if err == nil {
+					ctn.Close()
+					t.Fatal("dial succeeded without StartTLS")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ctn.Close()
+			if ctn.Upgraded || (ctn.StartTLSErr != nil) != c.refused {
+				t.Errorf("upgraded %t, StartTLS error %v", ctn.Upgraded, ctn.StartTLSErr)
+			}
+
+			start := time.Now()
+			if err := ctn.Bind("uid=jdoe,ou=people,dc=example,dc=com", "secret"); err != nil {
+				t.Errorf("bind after StartTLS %q: %s (%s)", c.policy, err, time.Since(start))
+			}
+		})
+	}
+}
+
+// Once the server agreed to StartTLS, a failed handshake must not fall back to cleartext.
+func TestStartTLSCertificate(t *testing.T) {
+	cert := serverCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	dir := testDirectory()
+	dir.StartTLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	url := startFakeDirectory(t, dir)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := x509.NewCertPool()
+	trusted.AddCert(leaf)
+
+	for _, c := range []struct {
+		name  string
+		roots *x509.CertPool
+	}{
+		{"untrusted", nil},
+		{"trusted", trusted},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			d := &Dialer{StartTLS: StartTLSOptional, DialTimeout: time.Second, TLSTimeout: time.Second, OperationTimeout: 2 * time.Second,
+				TLS: &tls.Config{RootCAs: c.roots}}
+			ctn, err := d.DialURL(url)
+			if c.roots == nil {
+				if err == nil {
+					ctn.Close()
+					t.Fatal("fell back to cleartext after a failed handshake")
+				}
+				if connectExit(err) != ExitTLS {
+					t.Errorf("exit code %d for %s", connectExit(err), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ctn.Close()
+			if !ctn.Upgraded {
+				t.Errorf("not upgraded: %v", ctn.StartTLSErr)
+			}
+			if err := ctn.Bind("uid=jdoe,ou=people,dc=example,dc=com", "secret"); err != nil {
+				t.Errorf("bind after StartTLS: %s", err)
+			}
+		})
+	}
+}
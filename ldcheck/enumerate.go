@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// entries kept as examples of each problem
+const enumerateExamples = 3
+
+// Enumeration sizes the directory as Security Hub would import it.
+type Enumeration struct {
+	UserScopes []UserScope `json:"user_scopes"`
+	Users      int         `json:"users"`
+	// Incomplete counts the users whose profile cannot be built.
+	Incomplete   int           `json:"incomplete"`
+	Problems     []EnumProblem `json:"problems,omitempty"`
+	DuplicateIDs []DuplicateID `json:"duplicate_ids,omitempty"`
+	GroupBase    string        `json:"group_base"`
+	GroupFilter  string        `json:"group_filter"`
+	Groups       int           `json:"groups"`
+	Pages        int           `json:"pages"`
+
+	byProblem map[string]int // index in Problems
+}
+
+// EnumProblem counts the users failing one profile check the same way.
+type EnumProblem struct {
+	Field     string   `json:"field"`
+	Attribute string   `json:"attribute"`
+	Verdict   string   `json:"verdict"`
+	Count     int      `json:"count"`
+	Examples  []string `json:"examples"`
+}
+
+// DuplicateID is a unique-id value shared by several users.
+type DuplicateID struct {
+	Value string   `json:"value"`
+	DNs   []string `json:"dns"`
+}
+
+// Enumerate counts the users and groups with paged searches, checking each user profile.
+func Enumerate(ctn *ldap.Conn, cfg LDAPConfig, pageSize uint32) (*Enumeration, error) {
+	if err := bindService(ctn, cfg); err != nil {
+		return nil, err
+	}
+
+	en := &Enumeration{byProblem: make(map[string]int)}
+	var err error
+	if en.UserScopes, err = UserScopes(cfg); err != nil {
+		return nil, err
+	}
+	en.GroupBase, en.GroupFilter = cfg.Groups.Base, cfg.Groups.Filter
+	if en.GroupBase == "" {
+		en.GroupBase = en.UserScopes[0].Base
+	}
+	if en.GroupFilter == "" {
+		en.GroupFilter = defaultGroupFilter
+	}
+
+	ids := make(map[string][]string)
+	for _, sc := range en.UserScopes {
+		err = pagedSearch(ctn, sc.Base, sc.Filter, cfg.Attributes.Names(), pageSize, func(e *ldap.Entry) {
+			en.Users++
+			broken := false
+			for _, v := range CheckAttributes(cfg.Attributes, e) {
+				if v.OK {
+					continue
+				}
+				broken = true
+				en.problem(v, e.DN)
+			}
+			if broken {
+				en.Incomplete++
+			}
+			if cfg.Attributes.UniqueID != "" {
+				for _, raw := range e.GetEqualFoldRawAttributeValues(cfg.Attributes.UniqueID) {
+					ids[printable(raw)] = append(ids[printable(raw)], e.DN)
+				}
+			}
+		}, &en.Pages)
+		if err != nil {
+			return nil, fmt.Errorf("enumerating users under %s: %w", sc.Base, err)
+		}
+	}
+	for v, dns := range ids {
+		if len(dns) > 1 {
+			en.DuplicateIDs = append(en.DuplicateIDs, DuplicateID{Value: v, DNs: dns})
+		}
+	}
+	sort.Slice(en.DuplicateIDs, func(i, j int) bool { return en.DuplicateIDs[i].Value < en.DuplicateIDs[j].Value })
+
+	err = pagedSearch(ctn, en.GroupBase, en.GroupFilter, []string{"1.1"}, pageSize, func(*ldap.Entry) { en.Groups++ }, &en.Pages)
+	if err != nil {
+		return nil, fmt.Errorf("enumerating groups under %s: %w", en.GroupBase, err)
+	}
+	return en, nil
+}
+
+func (en *Enumeration) problem(v AttributeVerdict, dn string) {
+	key := v.Field + "\x00" + v.Attribute + "\x00" + v.Verdict
+	i, ok := en.byProblem[key]
+	if !ok {
+		i = len(en.Problems)
+		en.byProblem[key] = i
+		en.Problems = append(en.Problems, EnumProblem{Field: v.Field, Attribute: v.Attribute, Verdict: v.Verdict})
+	}
+	p := &en.Problems[i]
+	p.Count++
+	if len(p.Examples) < enumerateExamples {
+		p.Examples = append(p.Examples, dn)
+	}
+}
+
+// pagedSearch hands each entry to f, one page at a time, so large directories are not held in memory.
+func pagedSearch(ctn *ldap.Conn, base, filter string, attrs []string, pageSize uint32, f func(*ldap.Entry), pages *int) error {
+	paging := ldap.NewControlPaging(pageSize)
+	req := ldap.NewSearchRequest(base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, attrs, []ldap.Control{paging})
+	for {
+		sr, err := ctn.Search(req)
+		if err != nil {
+			return err
+		}
+		*pages++
+		for _, e := range sr.Entries {
+			f(e)
+		}
+
+		next, ok := ldap.FindControl(sr.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(next.Cookie) == 0 {
+			return nil
+		}
+		paging.SetCookie(next.Cookie)
+	}
+}
+
+// UserScope is where users are found.
+type UserScope struct {
+	Base   string `json:"base"`
+	Filter string `json:"filter"`
+}
+
+// UserScopes lists where the users are: the user search, or the parents of the entries the bind patterns designate.
+func UserScopes(cfg LDAPConfig) ([]UserScope, error) {
+	if cfg.UserFilter != "" {
+		filter, err := renderPattern("user_filter", cfg.UserFilter, "*", func(s string) string { return s })
+		if err != nil {
+			return nil, fmt.Errorf("invalid user filter: %w", err)
+		}
+		return []UserScope{{cfg.UserSearchBase, filter}}, nil
+	}
+
+	var scopes []UserScope
+	for _, pattern := range cfg.Patterns() {
+		dn, err := RenderBindDN(pattern, "jdoe")
+		if err != nil {
+			return nil, err
+		}
+		p, err := ldap.ParseDN(dn)
+		if err != nil || len(p.RDNs) < 2 || len(p.RDNs[0].Attributes) != 1 || p.RDNs[0].Attributes[0].Value != "jdoe" {
+			continue // such as user principal names
+		}
+		_, parent, _ := strings.Cut(dn, ",")
+		scopes = append(scopes, UserScope{strings.TrimSpace(parent), "(" + p.RDNs[0].Attributes[0].Type + "=*)"})
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("cannot tell where the users are from the bind patterns, set user_filter and user_search_base")
+	}
+	return scopes, nil
+}
+
+func (en *Enumeration) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(en)
+}
+
+func (en *Enumeration) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "users: %d\n", en.Users)
+	for _, sc := range en.UserScopes {
+		fmt.Fprintf(w, "  under %s matching %s\n", sc.Base, sc.Filter)
+	}
+	fmt.Fprintf(w, "  %d complete, %d with an incomplete profile\n", en.Users-en.Incomplete, en.Incomplete)
+	for _, p := range en.Problems {
+		fmt.Fprintf(w, "  %6d  %s (%s): %s\n", p.Count, p.Field, p.Attribute, p.Verdict)
+		for _, dn := range p.Examples {
+			fmt.Fprintf(w, "            e.g. %s\n", dn)
+		}
+	}
+	if len(en.DuplicateIDs) > 0 {
+		fmt.Fprintf(w, "  %d unique-id values shared by several users:\n", len(en.DuplicateIDs))
+		for _, d := range en.DuplicateIDs {
+			fmt.Fprintf(w, "    %s: %s\n", d.Value, strings.Join(d.DNs, "; "))
+		}
+	}
+	fmt.Fprintf(w, "groups: %d under %s matching %s\n", en.Groups, en.GroupBase, en.GroupFilter)
+	fmt.Fprintf(w, "%d pages read\n", en.Pages)
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestEnumerate(t *testing.T) {
+	dir := testDirectory()
+	dir.Entries["uid=expired,ou=people,dc=example,dc=com"]["mail"] = []string{"jdoe@example.com"}
+	dir.Entries["cn=devs,ou=groups,dc=example,dc=com"] = map[string][]string{"objectClass": {"posixGroup"}}
+	ctn, err := ldap.DialURL(startFakeDirectory(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctn.Close()
+
+	cfg := LDAPConfig{
+		BindUserPattern: "uid={{.UserName}},ou=people,dc=example,dc=com",
+		Attributes:      AttributeMap{UniqueID: "mail"},
+		Groups:          GroupConfig{Base: "ou=groups,dc=example,dc=com"},
+	}
+	// one entry per page, 4 pages of users and 1 of groups
+	en, err := Enumerate(ctn, cfg, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if en.Pages != 5 {
+		t.Errorf("pages = %d, want 5", en.Pages)
+	}
+	if len(en.UserScopes) != 1 || en.UserScopes[0] != (UserScope{"ou=people,dc=example,dc=com", "(uid=*)"}) {
+		t.Errorf("user scopes = %+v", en.UserScopes)
+	}
+	// jdoe is complete; locked, dup and expired lack a display name, dup also the mail
+	if en.Users != 4 || en.Incomplete != 3 || en.Groups != 1 {
+		t.Errorf("users %d, incomplete %d, groups %d", en.Users, en.Incomplete, en.Groups)
+	}
+	missing := map[string]int{}
+	for _, p := range en.Problems {
+		missing[p.Field] += p.Count
+	}
+	if missing["display_name"] != 3 || missing["email"] != 1 {
+		t.Errorf("problems = %+v", en.Problems)
+	}
+	if len(en.DuplicateIDs) != 1 || en.DuplicateIDs[0].Value != "jdoe@example.com" || len(en.DuplicateIDs[0].DNs) != 2 {
+		t.Errorf("duplicates = %+v", en.DuplicateIDs)
+	}
+
+	en, err = Enumerate(ctn, cfg, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if en.Pages != 2 || en.Users != 4 || en.Groups != 1 {
+		t.Errorf("page size 100: pages %d, users %d, groups %d", en.Pages, en.Users, en.Groups)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Exit codes, one per failure class so monitoring can tell them apart.
+const (
+	ExitOK                 = 0
+	ExitFailure            = 1 // not classified
+	ExitUsage              = 2 // invalid command line, as the flag package does
+	ExitConfig             = 3 // configuration file unreadable or invalid
+	ExitNetwork            = 4 // DNS, connection refused, server unavailable
+	ExitTLS                = 5 // handshake or certificate verification failure
+	ExitInvalidCredentials = 6 // wrong user name or password
+	ExitAccountUnusable    = 7 // account disabled, locked, expired or restricted, or password expired
+	ExitSearch             = 8 // user lookup or post-bind search failed, access denied
+)
+
+const exitCodesUsage = `
+Exit codes:
+  0  success
+  1  unclassified failure, batch rows failing for different reasons, or broken users found by -enumerate
+  2  invalid command line
+  3  invalid configuration
+  4  network failure (DNS, connection refused, server unavailable)
+  5  TLS failure (handshake, certificate verification, pinning)
+  6  invalid credentials
+  7  account disabled, locked, expired or restricted, or password expired
+  8  user search or authorization failure
+`
+
+// fatal prints err and exits with code.
+func fatal(code int, err error) {
+	log.Print(err)
+	os.Exit(code)
+}
+
+// ExitCode classifies the failure of a login check.
+func ExitCode(res *Result) int {
+	if res.Err == nil {
+		return ExitOK
+	}
+
+	switch res.FailedPhase {
+	case PhaseConfig, PhaseResolve:
+		return ExitConfig
+	case PhaseDial:
+		return connectExit(res.Err)
+	}
+
+	var lerr *ldap.Error
+	switch {
+	case !errors.As(res.Err, &lerr) && res.FailedPhase == PhaseBind:
+		return ExitNetwork // connection lost, no LDAP result
+	case lerr == nil:
+		return ExitSearch
+	}
+	switch lerr.ResultCode {
+	case ldap.ErrorNetwork, ldap.LDAPResultBusy, ldap.LDAPResultUnavailable, ldap.LDAPResultServerDown,
+		ldap.LDAPResultTimeout, ldap.LDAPResultConnectError:
+		return ExitNetwork
+	case ldap.LDAPResultStrongAuthRequired, ldap.LDAPResultConfidentialityRequired,
+		ldap.LDAPResultInappropriateAuthentication, ldap.LDAPResultAuthMethodNotSupported:
+		return ExitConfig
+	}
+	if res.FailedPhase != PhaseBind {
+		return ExitSearch
+	}
+	if res.Account != nil && res.Account.PasswordPolicy != nil && res.Account.PasswordPolicy.Error != "" {
+		// OpenLDAP ppolicy answers invalid credentials, the control tells the account is locked or the password expired
+		return ExitAccountUnusable
+	}
+	switch lerr.ResultCode {
+	case ldap.LDAPResultInvalidCredentials:
+		if res.Bind != nil && res.Bind.Directory != nil && res.Bind.Directory.Unusable {
+			return ExitAccountUnusable
+		}
+		return ExitInvalidCredentials
+	case ldap.ErrorEmptyPassword:
+		return ExitInvalidCredentials
+	case ldap.LDAPResultUnwillingToPerform, ldap.LDAPResultConstraintViolation:
+		// used by OpenLDAP and 389-ds for disabled and locked accounts
+		return ExitAccountUnusable
+	}
+	return ExitFailure
+}
+
+// connectExit classifies a failure to establish a connection.
+func connectExit(err error) int {
+	var unreachable unreachableError
+	if errors.As(err, &unreachable) {
+		return ExitNetwork
+	}
+	if IsTLSError(err) {
+		return ExitTLS
+	}
+	return ExitNetwork
+}
+
+// IsTLSError reports whether err comes from the TLS layer rather than the network.
+// go-ldap does not wrap errors, so the messages are checked too.
+func IsTLSError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+
+	for err != nil {
+		var (
+			verr  *tls.CertificateVerificationError
+			rerr  tls.RecordHeaderError
+			uaerr x509.UnknownAuthorityError
+			herr  x509.HostnameError
+			cierr x509.CertificateInvalidError
+		)
+		if errors.Is(err, ErrPinMismatch) || errors.As(err, &verr) || errors.As(err, &rerr) ||
+			errors.As(err, &uaerr) || errors.As(err, &herr) || errors.As(err, &cierr) {
+			return true
+		}
+
+		var lerr *ldap.Error
+		if !errors.As(err, &lerr) {
+			break
+		}
+		err = lerr.Err
+	}
+
+	return strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:") || strings.Contains(msg, "TLS handshake failed") ||
+		strings.Contains(msg, "StartTLS")
+}
+
+// usageWithExitCodes extends the flag package usage with the exit codes documentation.
+func usageWithExitCodes() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprint(out, exitCodesUsage)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// GCComparison shows how the user entry differs between the domain partition and the global catalog.
+// The global catalog only replicates the partial attribute set, and the memberOf back-links of groups it holds.
+type GCComparison struct {
+	DomainURL string `json:"domain_url"`
+	GCURL     string `json:"gc_url"`
+	UserDN    string `json:"user_dn"`
+
+	// MissingFromGC are the attributes outside the partial attribute set.
+	MissingFromGC []string        `json:"missing_from_gc,omitempty"`
+	OnlyInGC      []string        `json:"only_in_gc,omitempty"`
+	Different     []AttributeDiff `json:"different,omitempty"`
+	// GroupsOnlyInGC are usually universal groups of other domains.
+	GroupsOnlyInGC []string `json:"groups_only_in_gc,omitempty"`
+	// GroupsOnlyInDomain are usually domain local groups, which the global catalog does not replicate memberships of.
+	GroupsOnlyInDomain []string `json:"groups_only_in_domain,omitempty"`
+
+	// GCProfile is the profile check through the global catalog.
+	GCProfile []AttributeVerdict `json:"gc_profile"`
+}
+
+type AttributeDiff struct {
+	Attribute string   `json:"attribute"`
+	Domain    []string `json:"domain"`
+	GC        []string `json:"gc"`
+}
+
+// CounterpartURL gives the global catalog URL of a domain controller, or the domain URL of a global catalog.
+func CounterpartURL(addr string) (string, error) {
+	ep, err := ParseURL(addr)
+	if err != nil {
+		return "", err
+	}
+	if ep.Network != "tcp" {
+		return "", fmt.Errorf("%s: no global catalog over %s", addr, ep.Network)
+	}
+	u := url.URL{Host: ep.Host}
+	switch {
+	case ep.GC && ep.Scheme == "ldaps":
+		u.Scheme = "ldaps"
+	case ep.GC:
+		u.Scheme = "ldap"
+	case ep.Scheme == "ldaps":
+		u.Scheme = "gcs"
+	default:
+		u.Scheme = "gc"
+	}
+	if strings.Contains(ep.Host, ":") {
+		u.Host = "[" + ep.Host + "]"
+	}
+	return u.String(), nil
+}
+
+// CompareGC reads the user entry as the user through both servers and reports the differences.
+func CompareGC(d *Dialer, cfg LDAPConfig, domainURL, gcURL, name, userdn, pass string) (*GCComparison, error) {
+	attrs := append([]string{"*", cfg.Groups.memberOfAttribute(cfg.Attributes)}, cfg.Attributes.Names()...)
+	read := func(addr string) (*ldap.Entry, error) {
+		ctn, err := d.DialURL(addr)
+		if err != nil {
+			return nil, err
+		}
+		defer ctn.Close()
+		if _, err := BindUser(ctn, cfg, name, userdn, pass); err != nil {
+			return nil, fmt.Errorf("bind: %w", err)
+		}
+		sr, err := ctn.Search(ldap.NewSearchRequest(userdn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+			"(&)", attrs, nil))
+		if err != nil {
+			return nil, err
+		}
+		if len(sr.Entries) != 1 {
+			return nil, fmt.Errorf("%d entries returned for %s", len(sr.Entries), userdn)
+		}
+		return sr.Entries[0], nil
+	}
+
+	de, err := read(domainURL)
+	if err != nil {
+		return nil, fmt.Errorf("reading the user through the domain controller %s: %w", domainURL, err)
+	}
+	ge, err := read(gcURL)
+	if err != nil {
+		return nil, fmt.Errorf("reading the user through the global catalog %s: %w", gcURL, err)
+	}
+
+	cmp := &GCComparison{DomainURL: domainURL, GCURL: gcURL, UserDN: userdn, GCProfile: CheckAttributes(cfg.Attributes, ge)}
+	memberOf := cfg.Groups.memberOfAttribute(cfg.Attributes)
+	values := func(e *ldap.Entry) map[string][]string {
+		m := make(map[string][]string)
+		for _, a := range e.Attributes {
+			for _, raw := range a.ByteValues {
+				m[strings.ToLower(a.Name)] = append(m[strings.ToLower(a.Name)], printable(raw))
+			}
+		}
+		return m
+	}
+	dv, gv := values(de), values(ge)
+	for _, a := range de.Attributes {
+		n := strings.ToLower(a.Name)
+		switch g, ok := gv[n]; {
+		case strings.EqualFold(n, memberOf):
+			cmp.GroupsOnlyInDomain = missingDNs(dv[n], g)
+			cmp.GroupsOnlyInGC = missingDNs(g, dv[n])
+		case !ok:
+			cmp.MissingFromGC = append(cmp.MissingFromGC, a.Name)
+		case !sameValues(dv[n], g):
+			cmp.Different = append(cmp.Different, AttributeDiff{Attribute: a.Name, Domain: dv[n], GC: g})
+		}
+	}
+	for _, a := range ge.Attributes {
+		n := strings.ToLower(a.Name)
+		switch _, ok := dv[n]; {
+		case strings.EqualFold(n, memberOf) && !ok:
+			cmp.GroupsOnlyInGC = gv[n]
+		case !ok:
+			cmp.OnlyInGC = append(cmp.OnlyInGC, a.Name)
+		}
+	}
+	sort.Strings(cmp.MissingFromGC)
+	sort.Strings(cmp.OnlyInGC)
+	return cmp, nil
+}
+
+// missingDNs lists the DNs of a missing from b.
+func missingDNs(a, b []string) []string {
+	var r []string
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if sameDN(x, y) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r = append(r, x)
+		}
+	}
+	return r
+}
+
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ProfileOK tells whether Security Hub could build the user profile through the global catalog.
+func (c *GCComparison) ProfileOK() bool {
+	for _, v := range c.GCProfile {
+		if !v.OK {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *GCComparison) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+func (c *GCComparison) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "user %s\n  domain controller: %s\n  global catalog:    %s\n", c.UserDN, c.DomainURL, c.GCURL)
+	list := func(title string, l []string) {
+		if len(l) == 0 {
+			return
+		}
+		fmt.Fprintln(w, title)
+		for _, x := range l {
+			fmt.Fprintln(w, "  "+x)
+		}
+	}
+	list("attributes missing from the global catalog (not in the partial attribute set):", c.MissingFromGC)
+	list("attributes only in the global catalog:", c.OnlyInGC)
+	if len(c.Different) > 0 {
+		fmt.Fprintln(w, "attributes with different values:")
+		for _, d := range c.Different {
+			fmt.Fprintf(w, "  %s: domain %s, global catalog %s\n", d.Attribute, strings.Join(d.Domain, "; "), strings.Join(d.GC, "; "))
+		}
+	}
+	list("groups only through the global catalog (universal groups of other domains):", c.GroupsOnlyInGC)
+	list("groups only through the domain controller (domain local groups are not in the global catalog):", c.GroupsOnlyInDomain)
+
+	fmt.Fprintln(w, "profile through the global catalog:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, v := range c.GCProfile {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", v.Field, v.Attribute, v.Verdict, strings.Join(v.Values, "; "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGCURL(t *testing.T) {
+	for _, c := range []struct{ url, addr, scheme, other string }{
+		{"gc://dc1.example.com", "dc1.example.com:3268", "ldap", "ldap://dc1.example.com"},
+		{"gcs://dc1.example.com", "dc1.example.com:3269", "ldaps", "ldaps://dc1.example.com"},
+		{"ldaps://dc1.example.com", "dc1.example.com:636", "ldaps", "gcs://dc1.example.com"},
+	} {
+		ep, err := ParseURL(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ep.Addr != c.addr || ep.Scheme != c.scheme || ep.GC != strings.HasPrefix(c.url, "gc") {
+			t.Errorf("%s: got %+v", c.url, ep)
+		}
+		if other, _ := CounterpartURL(c.url); other != c.other {
+			t.Errorf("%s: counterpart %s, want %s", c.url, other, c.other)
+		}
+	}
+}
+
+func TestCompareGC(t *testing.T) {
+	const (
+		user      = "uid=jdoe,ou=people,dc=example,dc=com"
+		global    = "cn=staff,ou=groups,dc=example,dc=com"
+		local     = "cn=printers,ou=groups,dc=example,dc=com"
+		universal = "cn=all,ou=groups,dc=other,dc=example,dc=com"
+	)
+	domain := testDirectory()
+	domain.Entries[user]["memberOf"] = []string{global, local}
+	gc := testDirectory()
+	gc.Entries[user] = map[string][]string{"uid": {"jdoe"}, "displayName": {"J. Doe"}, "memberOf": {universal, global}}
+
+	d, err := NewDialer(LDAPConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmp, err := CompareGC(d, LDAPConfig{}, startFakeDirectory(t, domain), startFakeDirectory(t, gc), "jdoe", user, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cmp.MissingFromGC, ",") != "mail" {
+		t.Errorf("missing from gc: %v", cmp.MissingFromGC)
+	}
+	if len(cmp.Different) != 1 || cmp.Different[0].Attribute != "displayName" {
+		t.Errorf("different: %+v", cmp.Different)
+	}
+	if strings.Join(cmp.GroupsOnlyInGC, ",") != universal || strings.Join(cmp.GroupsOnlyInDomain, ",") != local {
+		t.Errorf("groups only in gc %v, only in domain %v", cmp.GroupsOnlyInGC, cmp.GroupsOnlyInDomain)
+	}
+	if cmp.ProfileOK() {
+		t.Error("profile through the global catalog should lack the mail")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Group resolution strategies
+const (
+	StrategyMemberOf     = "memberOf"     // attribute on the user entry, nested through the groups memberOf
+	StrategyMember       = "member"       // groupOfNames and AD groups listing the user DN
+	StrategyUniqueMember = "uniqueMember" // groupOfUniqueNames listing the user DN
+	StrategyMemberUID    = "memberUid"    // posixGroup listing the user name
+	StrategyInChain      = "in_chain"     // AD LDAP_MATCHING_RULE_IN_CHAIN, nested membership computed by the server
+)
+
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+const defaultGroupFilter = "(|(objectClass=group)(objectClass=groupOfNames)(objectClass=groupOfUniqueNames)(objectClass=posixGroup))"
+
+// maximum nesting followed, protects against very deep or cyclic hierarchies
+const maxGroupDepth = 16
+
+type GroupConfig struct {
+	Strategies []string `toml:"strategies"` // any of memberOf, member, uniqueMember, memberUid, in_chain
+	Base       string   `toml:"base"`       // where groups are searched, for all strategies but memberOf
+	Filter     string   `toml:"filter"`     // restricts the entries considered groups
+	Nested     bool     `toml:"nested"`     // follow groups member of other groups
+}
+
+// Membership is a group the user belongs to.
+type Membership struct {
+	DN string `json:"dn"`
+	// Path lists the groups through which a nested group was reached, starting with a direct group.
+	Path []string `json:"path,omitempty"`
+}
+
+// GroupResult holds what one strategy found.
+type GroupResult struct {
+	Strategy string       `json:"strategy"`
+	Groups   []Membership `json:"groups"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// memberOfAttribute is the user attribute holding the groups, requested with the profile.
+func (g GroupConfig) memberOfAttribute(m AttributeMap) string {
+	if m.Groups != "" {
+		return m.Groups
+	}
+	return "memberOf"
+}
+
+func (g GroupConfig) uses(strategy string) bool {
+	for _, s := range g.Strategies {
+		if strings.EqualFold(s, strategy) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveGroups runs every configured strategy, each reporting the groups it found independently.
+func ResolveGroups(ctn *ldap.Conn, cfg LDAPConfig, name string, entry *ldap.Entry) []GroupResult {
+	g := cfg.Groups
+	filter := g.Filter
+	if filter == "" {
+		filter = defaultGroupFilter
+	}
+
+	search := func(base string, scope int, f string, attrs []string) ([]*ldap.Entry, error) {
+		sr, err := ctn.Search(ldap.NewSearchRequest(base, scope, ldap.NeverDerefAliases, 0, 0, false, f, attrs, nil))
+		if err != nil {
+			return nil, err
+		}
+		return sr.Entries, nil
+	}
+	containing := func(attr string) func(dn string) ([]string, error) {
+		return func(dn string) ([]string, error) {
+			es, err := search(g.Base, ldap.ScopeWholeSubtree, "(&"+filter+"("+attr+"="+ldap.EscapeFilter(dn)+"))", []string{"1.1"})
+			return dns(es), err
+		}
+	}
+
+	var results []GroupResult
+	for _, s := range g.Strategies {
+		res := GroupResult{Strategy: s}
+		var err error
+		switch {
+		case strings.EqualFold(s, StrategyMemberOf):
+			attr := g.memberOfAttribute(cfg.Attributes)
+			direct := entry.GetEqualFoldAttributeValues(attr)
+			res.Groups, err = walkGroups(direct, g.Nested, func(dn string) ([]string, error) {
+				es, err := search(dn, ldap.ScopeBaseObject, "(&)", []string{attr})
+				if err != nil || len(es) == 0 {
+					return nil, err
+				}
+				return es[0].GetEqualFoldAttributeValues(attr), nil
+			})
+		case strings.EqualFold(s, StrategyMember), strings.EqualFold(s, StrategyUniqueMember):
+			parents := containing(s)
+			var direct []string
+			if direct, err = parents(entry.DN); err == nil {
+				res.Groups, err = walkGroups(direct, g.Nested, parents)
+			}
+		case strings.EqualFold(s, StrategyMemberUID):
+			var es []*ldap.Entry
+			es, err = search(g.Base, ldap.ScopeWholeSubtree, "(&"+filter+"(memberUid="+ldap.EscapeFilter(name)+"))", []string{"1.1"})
+			for _, dn := range dns(es) {
+				res.Groups = append(res.Groups, Membership{DN: dn})
+			}
+		case strings.EqualFold(s, StrategyInChain):
+			var es []*ldap.Entry
+			es, err = search(g.Base, ldap.ScopeWholeSubtree, "(member:"+matchingRuleInChain+":="+ldap.EscapeFilter(entry.DN)+")", []string{"1.1"})
+			for _, dn := range dns(es) {
+				res.Groups = append(res.Groups, Membership{DN: dn})
+			}
+		default:
+			err = fmt.Errorf("unknown strategy: want %s, %s, %s, %s or %s",
+				StrategyMemberOf, StrategyMember, StrategyUniqueMember, StrategyMemberUID, StrategyInChain)
+		}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// walkGroups explores the groups breadth-first from the direct ones, recording the shortest path to each.
+func walkGroups(direct []string, nested bool, parents func(dn string) ([]string, error)) ([]Membership, error) {
+	var ms []Membership
+	seen := make(map[string]bool)
+	for _, dn := range direct {
+		if !seen[strings.ToLower(dn)] {
+			seen[strings.ToLower(dn)] = true
+			ms = append(ms, Membership{DN: dn})
+		}
+	}
+	if !nested {
+		return ms, nil
+	}
+
+	for i := 0; i < len(ms); i++ {
+		if len(ms[i].Path) >= maxGroupDepth {
+			continue
+		}
+		ps, err := parents(ms[i].DN)
+		if err != nil {
+			return ms, fmt.Errorf("reading groups of %s: %w", ms[i].DN, err)
+		}
+		for _, p := range ps {
+			if seen[strings.ToLower(p)] {
+				continue
+			}
+			seen[strings.ToLower(p)] = true
+			path := append(append([]string{}, ms[i].Path...), ms[i].DN)
+			ms = append(ms, Membership{DN: p, Path: path})
+		}
+	}
+	return ms, nil
+}
+
+func dns(es []*ldap.Entry) []string {
+	var r []string
+	for _, e := range es {
+		r = append(r, e.DN)
+	}
+	return r
+}
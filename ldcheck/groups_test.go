@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveGroups(t *testing.T) {
+	const (
+		user   = "uid=jdoe,ou=people,dc=example,dc=com"
+		admins = "cn=admins,ou=groups,dc=example,dc=com"
+		staff  = "cn=staff,ou=groups,dc=example,dc=com"
+		all    = "cn=all,ou=groups,dc=example,dc=com"
+		posix  = "cn=devs,ou=groups,dc=example,dc=com"
+	)
+	dir := testDirectory()
+	dir.Entries[user]["memberOf"] = []string{admins}
+	dir.Entries[admins] = map[string][]string{"objectClass": {"groupOfNames"}, "member": {user}, "memberOf": {staff}}
+	dir.Entries[staff] = map[string][]string{"objectClass": {"groupOfNames"}, "member": {admins}, "memberOf": {all}}
+	dir.Entries[all] = map[string][]string{"objectClass": {"groupOfNames"}, "member": {staff, admins}}
+	dir.Entries[posix] = map[string][]string{"objectClass": {"posixGroup"}, "memberUid": {"jdoe"}}
+
+	cfg := LDAPConfig{
+		ServerURL:       startFakeDirectory(t, dir),
+		BindUserPattern: "uid={{.UserName}},ou=people,dc=example,dc=com",
+		Groups: GroupConfig{
+			Strategies: []string{StrategyMemberOf, StrategyMember, StrategyMemberUID},
+			Base:       "ou=groups,dc=example,dc=com",
+			Nested:     true,
+		},
+	}
+	res := TestLoginWithLDAP(cfg, "jdoe", "secret")
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+
+	want := map[string]string{
+		StrategyMemberOf:  admins + "|" + staff + " via " + admins + "|" + all + " via " + admins + " > " + staff,
+		StrategyMember:    admins + "|" + all + " via " + admins + "|" + staff + " via " + admins,
+		StrategyMemberUID: posix,
+	}
+	for _, g := range res.Groups {
+		var got []string
+		for _, m := range g.Groups {
+			if len(m.Path) > 0 {
+				got = append(got, m.DN+" via "+strings.Join(m.Path, " > "))
+			} else {
+				got = append(got, m.DN)
+			}
+		}
+		if g.Error != "" || strings.Join(got, "|") != want[g.Strategy] {
+			t.Errorf("%s: got %q (error %q), want %q", g.Strategy, got, g.Error, want[g.Strategy])
+		}
+	}
+}
+
+func TestResolveGroupsFailing(t *testing.T) {
+	const user = "uid=jdoe,ou=people,dc=example,dc=com"
+	dir := testDirectory()
+	dir.Entries[user]["memberOf"] = []string{"cn=gone,ou=groups,dc=example,dc=com"}
+
+	for _, c := range []struct {
+		strategies []string
+		fails      bool
+	}{
+		{[]string{StrategyMemberOf, "memberof-typo"}, true},
+		{[]string{StrategyMemberOf, StrategyMember, "memberof-typo"}, false},
+	} {
+		cfg := LDAPConfig{
+			ServerURL:       startFakeDirectory(t, dir),
+			BindUserPattern: "uid={{.UserName}},ou=people,dc=example,dc=com",
+			Groups:          GroupConfig{Strategies: c.strategies, Base: "ou=groups,dc=example,dc=com", Nested: true},
+		}
+		res := TestLoginWithLDAP(cfg, "jdoe", "secret")
+		if c.fails && (res.FailedPhase != PhaseGroups || ExitCode(res) != ExitSearch) {
+			t.Errorf("%v: failed phase %q, exit code %d, want %s and %d", c.strategies, res.FailedPhase, ExitCode(res), PhaseGroups, ExitSearch)
+		}
+		if !c.fails && res.Err != nil {
+			t.Errorf("%v: %s", c.strategies, res.Err)
+		}
+	}
+}
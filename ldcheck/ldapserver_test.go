@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	crand "crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf16"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeDirectory is a minimal in-memory LDAP server, answering binds, searches and Who Am I.
+type fakeDirectory struct {
+	Entries   map[string]map[string][]string // DN → attributes
+	Passwords map[string]string              // DN, or user name for DIGEST-MD5 → password
+
+	// BindResult forces the result of binding as a DN, with the diagnostic message.
+	BindResult map[string]fakeResult
+
+	// NTHashes holds the hex NT hash of the users accepted by NTLM binds, by DOMAIN\user.
+	NTHashes map[string]string
+	// AuthzIDs is what Who Am I answers after a DIGEST-MD5 or NTLM bind, by user name; u:name by default.
+	AuthzIDs map[string]string
+	// External is the identity of SASL EXTERNAL binds, refused when empty.
+	External string
+	// DenyAnonymous refuses searches before a successful bind, as Active Directory does.
+	DenyAnonymous bool
+	// StartTLS upgrades the connections asking for it, refused with a protocol error when nil.
+	StartTLS *tls.Config
+
+	mx    sync.Mutex
+	binds int
+}
+
+type fakeResult struct {
+	Code    uint16
+	Message string
+
+	Matched string // matched DN, where NTLM puts its challenge
+	Creds   string // server SASL credentials
+
+	Controls []*ber.Packet
+}
+
+// fakeSession is the state of one connection.
+type fakeSession struct {
+	authz     string // answered by Who Am I
+	nonce     string // DIGEST-MD5 challenge
+	domain    string // from the NTLM negotiate message
+	challenge []byte // NTLM server challenge
+}
+
+// startFakeDirectory serves d on a local port, returning the ldap:// URL to reach it.
+func startFakeDirectory(t *testing.T, d *fakeDirectory) string {
+	t.Helper()
+	return "ldap://" + d.listen(t, "tcp", "127.0.0.1:0")
+}
+
+// startFakeDirectoryUnix serves d on a unix socket, returning the ldapi:// URL to reach it.
+func startFakeDirectoryUnix(t *testing.T, d *fakeDirectory) string {
+	t.Helper()
+	return "ldapi://" + d.listen(t, "unix", filepath.Join(t.TempDir(), "ldapi"))
+}
+
+func (d *fakeDirectory) listen(t *testing.T, network, addr string) string {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			cn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(cn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (d *fakeDirectory) serve(cn net.Conn) {
+	defer cn.Close()
+	var s fakeSession
+	for {
+		pkt, err := ber.ReadPacket(cn)
+		if err != nil || len(pkt.Children) < 2 {
+			return
+		}
+		id := pkt.Children[0].Value.(int64)
+		op := pkt.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			cn.Write(d.bindRequest(&s, op).response(id, ldap.ApplicationBindResponse))
+		case ldap.ApplicationSearchRequest:
+			if d.DenyAnonymous && s.authz == "" {
+				cn.Write(fakeResult{Code: ldap.LDAPResultInsufficientAccessRights}.response(id, ldap.ApplicationSearchResultDone))
+				continue
+			}
+			var controls []*ber.Packet
+			if len(pkt.Children) > 2 {
+				controls = pkt.Children[2].Children
+			}
+			d.search(cn, id, op, controls)
+		case ldap.ApplicationExtendedRequest:
+			if op.Children[0].Data.String() == "1.3.6.1.4.1.1466.20037" && d.StartTLS != nil {
+				cn.Write(fakeResult{}.response(id, ldap.ApplicationExtendedResponse))
+				cn = tls.Server(cn, d.StartTLS)
+				continue
+			}
+			if op.Children[0].Data.String() != ldap.ControlTypeWhoAmI {
+				cn.Write(fakeResult{Code: ldap.LDAPResultProtocolError, Message: "unsupported extended operation"}.response(id, ldap.ApplicationExtendedResponse))
+				continue
+			}
+			resp := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationExtendedResponse, nil, "")
+			resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 0, ""))
+			resp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+			resp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+			resp.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 11, s.authz, ""))
+			cn.Write(envelope(id, resp).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (d *fakeDirectory) bindRequest(s *fakeSession, op *ber.Packet) fakeResult {
+	d.mx.Lock()
+	d.binds++
+	d.mx.Unlock()
+
+	s.authz = ""
+	auth := op.Children[2]
+	switch auth.Tag {
+	case 0: // simple
+		dn := op.Children[1].Value.(string)
+		r := d.bind(dn, auth.Data.String())
+		if r.Code == 0 && dn != "" {
+			s.authz = "dn:" + dn
+		}
+		return r
+	case 3: // SASL
+		switch mech := auth.Children[0].Value.(string); {
+		case mech == "EXTERNAL" && d.External != "":
+			s.authz = d.External
+			return fakeResult{}
+		case mech == "DIGEST-MD5" && len(auth.Children) == 1:
+			s.nonce = fmt.Sprintf("nonce%d", rand.Int63())
+			return fakeResult{Code: ldap.LDAPResultSaslBindInProgress, Creds: `realm="example.com",nonce="` + s.nonce + `",qop="auth",charset=utf-8,algorithm=md5-sess`}
+		case mech == "DIGEST-MD5":
+			return d.digestMD5(s, auth.Children[1].Data.String())
+		}
+		return fakeResult{Code: ldap.LDAPResultAuthMethodNotSupported}
+	case 10: // NTLM negotiate
+		return d.ntlmChallenge(s, auth.Data.Bytes())
+	case 11: // NTLM authenticate
+		return d.ntlmAuthenticate(s, auth.Data.Bytes())
+	}
+	return fakeResult{Code: ldap.LDAPResultAuthMethodNotSupported}
+}
+
+func (d *fakeDirectory) bind(dn, pass string) fakeResult {
+	if r, ok := d.BindResult[dn]; ok {
+		return r
+	}
+	if pw, ok := d.Passwords[dn]; ok && pw == pass {
+		return fakeResult{}
+	}
+	return fakeResult{Code: ldap.LDAPResultInvalidCredentials}
+}
+
+// digestMD5 checks the response to the challenge (RFC 2831).
+func (d *fakeDirectory) digestMD5(s *fakeSession, creds string) fakeResult {
+	params := make(map[string]string)
+	for _, kv := range strings.Split(creds, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		params[k] = strings.Trim(v, `"`)
+	}
+	md5hex := func(s string) string {
+		h := md5.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+
+	user := params["username"]
+	pw, ok := d.Passwords[user]
+	if !ok || params["nonce"] != s.nonce {
+		return fakeResult{Code: ldap.LDAPResultInvalidCredentials}
+	}
+	secret := md5.Sum([]byte(user + ":" + params["realm"] + ":" + pw))
+	a1 := string(secret[:]) + ":" + s.nonce + ":" + params["cnonce"]
+	a2 := "AUTHENTICATE:" + params["digest-uri"]
+	want := md5hex(md5hex(a1) + ":" + s.nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":" + params["qop"] + ":" + md5hex(a2))
+	if params["response"] != want {
+		return fakeResult{Code: ldap.LDAPResultInvalidCredentials}
+	}
+	s.authz = d.authzID(user)
+	return fakeResult{}
+}
+
+func (d *fakeDirectory) authzID(user string) string {
+	if id, ok := d.AuthzIDs[user]; ok {
+		return id
+	}
+	return "u:" + user
+}
+
+// ntlmChallenge records the domain of the negotiate message and answers with a challenge (MS-NLMP 2.2.1).
+func (d *fakeDirectory) ntlmChallenge(s *fakeSession, neg []byte) fakeResult {
+	if len(neg) < 24 || !bytes.HasPrefix(neg, []byte("NTLMSSP\x00")) {
+		return fakeResult{Code: ldap.LDAPResultProtocolError}
+	}
+	n, off := binary.LittleEndian.Uint16(neg[16:]), binary.LittleEndian.Uint32(neg[20:])
+	if int(off)+int(n) <= len(neg) {
+		s.domain = string(neg[off : off+uint32(n)])
+	}
+	s.challenge = make([]byte, 8)
+	crand.Read(s.challenge)
+
+	const (
+		unicode     = 1 << 0
+		ntlm        = 1 << 9
+		extended    = 1 << 19
+		targetInfo  = 1 << 23
+		payloadAddr = 48
+	)
+	msg := []byte("NTLMSSP\x00")
+	msg = binary.LittleEndian.AppendUint32(msg, 2)
+	msg = append(msg, 0, 0, 0, 0) // no target name
+	msg = binary.LittleEndian.AppendUint32(msg, payloadAddr)
+	msg = binary.LittleEndian.AppendUint32(msg, unicode|ntlm|extended|targetInfo)
+	msg = append(msg, s.challenge...)
+	msg = append(msg, make([]byte, 8)...)
+	msg = append(msg, 4, 0, 4, 0) // target info, only the end of list
+	msg = binary.LittleEndian.AppendUint32(msg, payloadAddr)
+	msg = append(msg, 0, 0, 0, 0)
+	return fakeResult{Matched: string(msg)}
+}
+
+// ntlmAuthenticate checks the NTLMv2 response of DOMAIN\user, the domain coming from the negotiate message.
+func (d *fakeDirectory) ntlmAuthenticate(s *fakeSession, msg []byte) fakeResult {
+	field := func(at int) []byte {
+		if len(msg) < at+8 {
+			return nil
+		}
+		n, off := binary.LittleEndian.Uint16(msg[at:]), binary.LittleEndian.Uint32(msg[at+4:])
+		if int(off)+int(n) > len(msg) {
+			return nil
+		}
+		return msg[off : off+uint32(n)]
+	}
+	utf16le := func(s string) []byte {
+		var b []byte
+		for _, r := range utf16.Encode([]rune(s)) {
+			b = append(b, byte(r), byte(r>>8))
+		}
+		return b
+	}
+	hmacMD5 := func(key []byte, data ...[]byte) []byte {
+		h := hmac.New(md5.New, key)
+		for _, d := range data {
+			h.Write(d)
+		}
+		return h.Sum(nil)
+	}
+	fromUTF16 := func(b []byte) string {
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+		return string(utf16.Decode(u))
+	}
+
+	nt, target, user := field(20), fromUTF16(field(28)), fromUTF16(field(36))
+	name := s.domain + `\` + user
+	hash, err := hex.DecodeString(d.NTHashes[name])
+	if err != nil || len(hash) == 0 || len(nt) <= 16 {
+		return fakeResult{Code: ldap.LDAPResultInvalidCredentials, Message: "unknown user " + name}
+	}
+	key := hmacMD5(hash, utf16le(strings.ToUpper(user)+target))
+	if !hmac.Equal(nt[:16], hmacMD5(key, s.challenge, nt[16:])) {
+		return fakeResult{Code: ldap.LDAPResultInvalidCredentials, Message: "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 52e, v4563"}
+	}
+	s.authz = d.authzID(name)
+	return fakeResult{}
+}
+
+// search answers in pages with the paging control, the cookie being the index of the next entry.
+func (d *fakeDirectory) search(cn net.Conn, id int64, op *ber.Packet, controls []*ber.Packet) {
+	base := strings.ToLower(op.Children[0].Value.(string))
+	scope := op.Children[1].Value.(int64)
+	filter := op.Children[6]
+	var want []string
+	for _, a := range op.Children[7].Children {
+		want = append(want, a.Value.(string))
+	}
+
+	// answer in a stable order, as real directories do
+	sorted := make([]string, 0, len(d.Entries))
+	for dn := range d.Entries {
+		sorted = append(sorted, dn)
+	}
+	sort.Strings(sorted)
+
+	found := false
+	var entries []*ber.Packet
+	for _, dn := range sorted {
+		attrs := d.Entries[dn]
+		ldn := strings.ToLower(dn)
+		switch scope {
+		case ldap.ScopeBaseObject:
+			if ldn != base {
+				continue
+			}
+		case ldap.ScopeSingleLevel:
+			if _, parent, _ := strings.Cut(ldn, ","); parent != base {
+				continue
+			}
+		default:
+			if ldn != base && !strings.HasSuffix(ldn, ","+base) && base != "" {
+				continue
+			}
+		}
+		if ldn == base {
+			found = true
+		}
+		if !matchFilter(filter, attrs) {
+			continue
+		}
+
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+		list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		for name, values := range attrs {
+			if !wantAttribute(want, name) {
+				continue
+			}
+			attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, v := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+			}
+			attr.AppendChild(set)
+			list.AppendChild(attr)
+		}
+		entry.AppendChild(list)
+		entries = append(entries, entry)
+	}
+
+	done := fakeResult{}
+	if scope == ldap.ScopeBaseObject && !found {
+		done.Code = ldap.LDAPResultNoSuchObject
+	}
+	for _, c := range controls {
+		ctrl, _ := ldap.DecodeControl(c)
+		paging, ok := ctrl.(*ldap.ControlPaging)
+		if !ok || paging.PagingSize == 0 {
+			continue
+		}
+		from, _ := strconv.Atoi(string(paging.Cookie))
+		if from > len(entries) {
+			from = len(entries)
+		}
+		entries = entries[from:]
+		next := ldap.NewControlPaging(paging.PagingSize)
+		if len(entries) > int(paging.PagingSize) {
+			entries = entries[:paging.PagingSize]
+			next.SetCookie([]byte(strconv.Itoa(from + len(entries))))
+		}
+		done.Controls = append(done.Controls, next.Encode())
+	}
+	for _, e := range entries {
+		cn.Write(envelope(id, e).Bytes())
+	}
+	cn.Write(done.response(id, ldap.ApplicationSearchResultDone))
+}
+
+func wantAttribute(want []string, name string) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, w := range want {
+		if w == "*" || strings.EqualFold(w, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFilter(f *ber.Packet, attrs map[string][]string) bool {
+	values := func(name string) []string {
+		for n, v := range attrs {
+			if strings.EqualFold(n, name) {
+				return v
+			}
+		}
+		return nil
+	}
+
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !matchFilter(c, attrs) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if matchFilter(c, attrs) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matchFilter(f.Children[0], attrs)
+	case ldap.FilterPresent:
+		return strings.EqualFold(f.Data.String(), "objectClass") || len(values(f.Data.String())) > 0
+	case ldap.FilterEqualityMatch:
+		for _, v := range values(f.Children[0].Data.String()) {
+			if strings.EqualFold(v, f.Children[1].Data.String()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r fakeResult) response(id int64, tag ber.Tag) []byte {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(r.Code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, r.Matched, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, r.Message, ""))
+	if r.Creds != "" {
+		op.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 7, r.Creds, ""))
+	}
+	pkt := envelope(id, op)
+	if len(r.Controls) > 0 {
+		ctrls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "")
+		for _, c := range r.Controls {
+			ctrls.AppendChild(c)
+		}
+		pkt.AppendChild(ctrls)
+	}
+	return pkt.Bytes()
+}
+
+// ppolicyControl is the password policy response control, with the time before expiration
+// and the error when they are not negative.
+func ppolicyControl(expire, code int64) *ber.Packet {
+	value := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	if expire >= 0 {
+		warning := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "")
+		warning.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimitive, 0, expire, ""))
+		value.AppendChild(warning)
+	}
+	if code >= 0 {
+		value.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimitive, 1, code, ""))
+	}
+	ctrl := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	ctrl.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, ldap.ControlTypeBeheraPasswordPolicy, ""))
+	ctrl.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(value.Bytes()), ""))
+	return ctrl
+}
+
+func envelope(id int64, op *ber.Packet) *ber.Packet {
+	pkt := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	pkt.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	pkt.AppendChild(op)
+	return pkt
+}
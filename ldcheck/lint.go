@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// user names the bind pattern and user filter are tried with
+var lintSamples = []string{"jdoe", "John Smith", "smith, john", "jdoe@example.com", `CORP\jdoe`}
+
+type Finding struct {
+	Level   string // error, warning or info
+	Message string
+}
+
+func (f Finding) String() string { return f.Level + ": " + f.Message }
+
+// Lint checks the configuration without contacting the server.
+func Lint(md toml.MetaData, cfg LDAPConfig) []Finding {
+	var fs []Finding
+	errorf := func(format string, args ...any) { fs = append(fs, Finding{"error", fmt.Sprintf(format, args...)}) }
+	warnf := func(format string, args ...any) { fs = append(fs, Finding{"warning", fmt.Sprintf(format, args...)}) }
+	infof := func(format string, args ...any) { fs = append(fs, Finding{"info", fmt.Sprintf(format, args...)}) }
+
+	known := configKeys(reflect.TypeOf(Config{}), "")
+	for _, k := range md.Undecoded() {
+		if s := Closest(k.String(), known); s != "" {
+			errorf("unknown key %s, did you mean %s?", k, s)
+		} else {
+			errorf("unknown key %s", k)
+		}
+	}
+
+	var eps []Endpoint
+	if len(cfg.Servers()) == 0 {
+		errorf("none of server_url, server_urls and domain is set")
+	}
+	for _, u := range cfg.Servers() {
+		if name, scheme, ok, err := ParseSRVURL(u); ok {
+			if err != nil {
+				errorf("invalid server URL %s", err)
+				continue
+			}
+			infof("servers are discovered from the %s SRV records", name)
+			eps = append(eps, Endpoint{Scheme: scheme, Network: "tcp"})
+			continue
+		}
+		ep, err := ParseURL(u)
+		if err != nil {
+			errorf("invalid server URL %s: %s", u, err)
+			continue
+		}
+		if ep.Network == "tcp" {
+			host, port, _ := net.SplitHostPort(ep.Addr)
+			if host == "" {
+				errorf("server URL %s has no host", u)
+			}
+			if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+				errorf("server URL %s has an invalid port %q", u, port)
+			}
+		}
+		if ep.GC {
+			warnf("%s is a global catalog: it only holds the partial attribute set, check the profile with -gc-compare", u)
+		}
+		eps = append(eps, ep)
+	}
+	cleartext := false
+	for _, ep := range eps {
+		cleartext = cleartext || ep.Scheme == "ldap"
+	}
+
+	switch cfg.StartTLS {
+	case "", StartTLSOff, StartTLSOptional, StartTLSRequired:
+	default:
+		errorf("invalid starttls value %q: want %s, %s or %s", cfg.StartTLS, StartTLSOff, StartTLSOptional, StartTLSRequired)
+	}
+	if cleartext {
+		switch cfg.StartTLS {
+		case "", StartTLSOff:
+			warnf("server URL uses ldap:// without StartTLS: passwords are sent in cleartext")
+		case StartTLSOptional:
+			warnf("starttls is optional: a network attacker can strip it and read passwords")
+		}
+	} else if cfg.StartTLS != "" && cfg.StartTLS != StartTLSOff && len(eps) > 0 {
+		warnf("starttls is ignored for %s:// URLs", eps[0].Scheme)
+	}
+	if cfg.Retry.Attempts > 1 && len(cfg.Servers()) > 1 {
+		infof("each server is tried %d times before failing over to the next", cfg.Retry.Attempts)
+	}
+
+	if _, err := cfg.TLS.Load(); err != nil {
+		errorf("invalid TLS configuration: %s", err)
+	}
+	if cfg.TLS.InsecureSkipVerify {
+		warnf("insecure_skip_verify disables certificate verification")
+	}
+	if cfg.TLS.MinVersion == "1.0" || cfg.TLS.MinVersion == "1.1" {
+		warnf("min_version %s allows deprecated TLS versions", cfg.TLS.MinVersion)
+	}
+
+	if err := validBindMethod(cfg.BindMethod); err != nil {
+		errorf("%s", err)
+	}
+	switch strings.ToLower(cfg.BindMethod) {
+	case BindExternal:
+		for _, ep := range eps {
+			if ep.Network != "unix" && (cfg.TLS.ClientCert == "" || ep.Scheme == "ldap" && cfg.StartTLS != StartTLSRequired) {
+				errorf("bind_method external needs an ldapi:// URL, or TLS with a client_cert")
+				break
+			}
+		}
+	case BindDigestMD5:
+		warnf("DIGEST-MD5 is deprecated (RFC 6331) and disabled on many servers")
+	case BindNTLM:
+		if cfg.NTLMDomain == "" {
+			infof("ntlm_domain is not set: users must log in as DOMAIN\\user or user@domain")
+		}
+	}
+	if cfg.NTLMHash && !strings.EqualFold(cfg.BindMethod, BindNTLM) {
+		warnf("ntlm_hash is ignored unless bind_method is ntlm")
+	}
+
+	switch {
+	case len(cfg.Patterns()) > 0 && cfg.UserFilter != "":
+		errorf("bind_pattern and user_filter are mutually exclusive")
+	case len(cfg.Patterns()) == 0 && cfg.UserFilter == "" && isSASL(cfg.BindMethod):
+		infof("neither bind_pattern nor user_filter is set: the user entry is found with the Who Am I operation")
+	case len(cfg.Patterns()) == 0 && cfg.UserFilter == "":
+		errorf("neither bind_pattern nor user_filter is set")
+	case len(cfg.Patterns()) > 0:
+		for _, p := range cfg.Patterns() {
+			for _, name := range lintSamples {
+				dn, err := RenderBindDN(p, name)
+				if err != nil {
+					errorf("%s", err)
+					break
+				}
+				if _, err := ldap.ParseDN(dn); err != nil && !strings.Contains(dn, "@") && !strings.Contains(dn, `\`) {
+					warnf("bind pattern %s gives an invalid DN for user %q: %s (%s)", p, name, dn, err)
+				} else {
+					infof("user %q binds as %s", name, dn)
+				}
+			}
+			if dn, _ := RenderBindDN(p, "jdoe"); !strings.Contains(dn, "jdoe") {
+				errorf("bind pattern %s does not use the user name: every user would bind as %q", p, dn)
+			}
+			if isDNPattern(p) {
+				for _, r := range CheckInjection(p, false) {
+					switch {
+					case r.Error != nil:
+						errorf("injection self-test: bind pattern %s fails for user %q: %s", p, r.UserName, r.Error)
+					case !r.Safe:
+						errorf("injection self-test: user %q changes the structure of the DN: %s", r.UserName, r.Rendered)
+					default:
+						infof("injection self-test: user %q binds as %s", r.UserName, r.Rendered)
+					}
+				}
+			}
+		}
+		if len(cfg.Patterns()) > 1 && cfg.BindDN == "" {
+			infof("with several bind patterns, the next one is only tried when the server tells missing entries apart, or when bind_dn or anonymous reads let the entry be looked up")
+		}
+	case cfg.UserFilter != "":
+		for _, name := range lintSamples {
+			filter, err := RenderUserFilter(cfg.UserFilter, name)
+			if err != nil {
+				errorf("%s", err)
+				break
+			}
+			if _, err := ldap.CompileFilter(filter); err != nil {
+				errorf("user_filter gives an invalid filter for user %q: %s (%s)", name, filter, err)
+				break
+			}
+			infof("user %q is searched with %s", name, filter)
+		}
+		if f, _ := RenderUserFilter(cfg.UserFilter, "jdoe"); !strings.Contains(f, "jdoe") {
+			errorf("user_filter does not use the user name: %s", f)
+		}
+		for _, r := range CheckInjection(cfg.UserFilter, true) {
+			switch {
+			case r.Error != nil:
+				errorf("injection self-test: user_filter fails for user %q: %s", r.UserName, r.Error)
+			case !r.Safe:
+				errorf("injection self-test: user %q changes the structure of the filter: %s", r.UserName, r.Rendered)
+			default:
+				infof("injection self-test: user %q is searched with %s", r.UserName, r.Rendered)
+			}
+		}
+		if cfg.UserSearchBase == "" {
+			warnf("user_search_base is not set: the search starts at the root of the directory")
+		}
+		if cfg.BindDN == "" {
+			warnf("bind_dn is not set: the user search is anonymous")
+		}
+	}
+
+	for _, st := range cfg.Groups.Strategies {
+		switch {
+		case strings.EqualFold(st, StrategyMemberOf):
+		case strings.EqualFold(st, StrategyMember), strings.EqualFold(st, StrategyUniqueMember),
+			strings.EqualFold(st, StrategyMemberUID), strings.EqualFold(st, StrategyInChain):
+			if cfg.Groups.Base == "" {
+				warnf("groups strategy %s without groups base: the search starts at the root of the directory", st)
+			}
+		default:
+			errorf("unknown groups strategy %q", st)
+		}
+	}
+	if cfg.Groups.Filter != "" {
+		if _, err := ldap.CompileFilter(cfg.Groups.Filter); err != nil {
+			errorf("invalid groups filter: %s", err)
+		}
+	}
+
+	if cfg.Roles.RequiredFilter != "" {
+		if _, err := ldap.CompileFilter(cfg.Roles.RequiredFilter); err != nil {
+			errorf("invalid roles required_filter: %s", err)
+		}
+	}
+	if _, ok := roleRanks[cfg.Roles.DefaultRole]; cfg.Roles.DefaultRole != "" && !ok {
+		errorf("unknown default_role %q: want admin, analyst or viewer", cfg.Roles.DefaultRole)
+	}
+	for i, r := range cfg.Roles.Rules {
+		if _, ok := roleRanks[r.Role]; !ok {
+			errorf("role rule #%d: unknown role %q: want admin, analyst or viewer", i+1, r.Role)
+		}
+		switch {
+		case (r.Group == "") == (r.Filter == ""):
+			errorf("role rule #%d: exactly one of group and filter must be set", i+1)
+		case r.Group != "":
+			if _, err := ldap.ParseDN(r.Group); err != nil {
+				errorf("role rule #%d: group is not a valid DN: %s", i+1, err)
+			}
+		default:
+			if _, err := ldap.CompileFilter(r.Filter); err != nil {
+				errorf("role rule #%d: invalid filter: %s", i+1, err)
+			}
+		}
+	}
+
+	for _, dn := range [][2]string{{"bind_dn", cfg.BindDN}, {"user_search_base", cfg.UserSearchBase}, {"groups.base", cfg.Groups.Base}} {
+		if _, err := ldap.ParseDN(dn[1]); dn[1] != "" && err != nil {
+			errorf("%s is not a valid DN: %s", dn[0], err)
+		}
+	}
+
+	return fs
+}
+
+func HasErrors(fs []Finding) bool {
+	for _, f := range fs {
+		if f.Level == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+func WriteFindings(w io.Writer, fs []Finding) {
+	for _, f := range fs {
+		fmt.Fprintln(w, f)
+	}
+	if !HasErrors(fs) {
+		fmt.Fprintln(w, "configuration ok")
+	}
+}
+
+// configKeys lists the keys accepted in the configuration file, using the toml tags of t.
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("toml"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			keys = append(keys, configKeys(f.Type, prefix+name+".")...)
+			continue
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct:
+			// arrays of tables, whose keys have no index
+			keys = append(keys, configKeys(f.Type.Elem(), prefix+name+".")...)
+			continue
+		}
+		keys = append(keys, prefix+name)
+	}
+	return keys
+}
+
+// Closest returns the candidate nearest to s, ignoring case, or "" if none is a likely typo.
+func Closest(s string, candidates []string) string {
+	best, bestd := "", len(s)/3+1
+	for _, c := range candidates {
+		if d := levenshtein(strings.ToLower(s), strings.ToLower(c)); d < bestd {
+			best, bestd = c, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(v ...int) int {
+	m := v[0]
+	for _, x := range v[1:] {
+		if x < m {
+			m = x
+		}
+	}
+	return m
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestLint(t *testing.T) {
+	for _, c := range []struct {
+		name, conf string
+		want       []string // expected substrings of the findings
+		ok         bool
+	}{
+		{"typo", `
+[LDAP]
+server_url = "ldaps://dc1.example.com"
+bind_patern = "uid={{.UserName}},dc=example,dc=com"
+`, []string{"unknown key LDAP.bind_patern, did you mean LDAP.bind_pattern?", "neither bind_pattern nor user_filter"}, false},
+		{"typo in role rule", `
+[LDAP]
+server_url = "ldaps://dc1.example.com"
+bind_pattern = "uid={{.UserName}},dc=example,dc=com"
+[[LDAP.roles.rules]]
+role = "admin"
+gruop = "cn=admins,dc=example,dc=com"
+`, []string{"unknown key LDAP.roles.rules.gruop, did you mean LDAP.roles.rules.group?"}, false},
+		{"cleartext", `
+[LDAP]
+server_url = "ldap://dc1.example.com"
+bind_pattern = "uid={{.UserName}},dc=example,dc=com"
+`, []string{"without StartTLS", `user "jdoe" binds as uid=jdoe,dc=example,dc=com`}, true},
+		{"bad port", `
+[LDAP]
+server_url = "ldaps://dc1.example.com:0"
+user_filter = "(uid={{.UserName}})"
+`, []string{"invalid port", "user_search_base is not set"}, false},
+		{"bad filter", `
+[LDAP]
+server_url = "ldaps://dc1.example.com"
+user_filter = "uid={{.UserName}}"
+user_search_base = "dc=example,dc=com"
+`, []string{"invalid filter"}, false},
+		{"constant pattern", `
+[LDAP]
+server_url = "ldaps://dc1.example.com"
+bind_pattern = "uid=admin,dc=example,dc=com"
+`, []string{"does not use the user name"}, false},
+		{"helpers", `
+[LDAP]
+server_url = "ldaps://dc1.example.com"
+bind_pattern = "uid={{userFromUPN .UserName | lower}},ou=people,dc=example,dc=com"
+`, []string{`user "jdoe@example.com" binds as uid=jdoe,ou=people`, `injection self-test: user "jdoe,ou=admins" binds as uid=jdoe\,ou\=admins,ou=people,dc=example,dc=com`}, true},
+		{"external without certificate", `
+[LDAP]
+server_url = "ldaps://dc1.example.com"
+bind_method = "external"
+`, []string{"bind_method external needs", "Who Am I"}, false},
+		{"ntlm", `
+[LDAP]
+server_url = "ldaps://dc1.example.com"
+bind_method = "ntlm"
+user_filter = "(sAMAccountName={{.UserName}})"
+user_search_base = "dc=example,dc=com"
+bind_dn = "cn=svc,dc=example,dc=com"
+`, []string{"ntlm_domain is not set"}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var cfg Config
+			md, err := toml.Decode(c.conf, &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs := Lint(md, cfg.LDAP)
+
+			var out strings.Builder
+			WriteFindings(&out, fs)
+			for _, w := range c.want {
+				if !strings.Contains(out.String(), w) {
+					t.Errorf("missing finding %q in:\n%s", w, out.String())
+				}
+			}
+			if HasErrors(fs) == c.ok {
+				t.Errorf("errors = %t, want %t:\n%s", !c.ok, c.ok, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// TestLoginWithLDAP goes through the same steps as a Security Hub login, recording each of them in the result.
+// It stops at the first failing phase; the error is available in Result.Err.
+func TestLoginWithLDAP(cfg LDAPConfig, name, pass string) *Result {
+	res := &Result{URL: cfg.ServerURL, UserName: name}
+	ctn, err := Connect(res, cfg)
+	if err != nil {
+		return res
+	}
+	defer ctn.Close()
+
+	CheckLogin(ctn, cfg, name, pass, res)
+	return res
+}
+
+// Connect validates the configuration and dials the server, recording both phases in res.
+func Connect(res *Result, cfg LDAPConfig) (*Conn, error) {
+	var dialer *Dialer
+	err := res.Phase(PhaseConfig, func() (err error) {
+		if cfg.UserFilter != "" && len(cfg.Patterns()) > 0 {
+			return errors.New("bind_pattern and user_filter are mutually exclusive")
+		}
+		if err := validBindMethod(cfg.BindMethod); err != nil {
+			return err
+		}
+		dialer, err = NewDialer(cfg)
+		if err != nil {
+			return fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ctn *Conn
+	err = res.Phase(PhaseDial, func() (err error) {
+		ctn, res.Servers, err = dialer.DialServers(cfg.Servers())
+		if n := len(res.Servers); n > 0 {
+			res.URL = res.Servers[n-1].URL
+		}
+		if err != nil {
+			return fmt.Errorf("cannot contact LDAP server: %w", err)
+		}
+		res.SetConn(ctn)
+		return nil
+	})
+	return ctn, err
+}
+
+// CheckLogin resolves the user DN, binds and reads the user attributes over an established connection.
+func CheckLogin(ctn *Conn, cfg LDAPConfig, name, pass string, res *Result) {
+	var err error
+	var candidates []PatternAttempt // bind DNs rendered from the patterns
+	if cfg.UserFilter != "" {
+		err = res.Phase(PhaseSearch, func() (err error) {
+			res.UserFilter, err = RenderUserFilter(cfg.UserFilter, name)
+			if err != nil {
+				return err
+			}
+			res.UserDN, err = SearchUserDN(ctn.Conn, cfg, res.UserFilter)
+			switch {
+			case errors.Is(err, ErrUserNotFound):
+				return fmt.Errorf("user %s not found under %s: %w", name, cfg.UserSearchBase, err)
+			case errors.Is(err, ErrMultipleUsers):
+				return fmt.Errorf("user %s is ambiguous under %s: %w", name, cfg.UserSearchBase, err)
+			}
+			return err
+		})
+	} else if len(cfg.Patterns()) > 0 || !isSASL(cfg.BindMethod) {
+		err = res.Phase(PhaseResolve, func() error {
+			patterns := cfg.Patterns()
+			if len(patterns) == 0 {
+				patterns = []string{""}
+			}
+			for _, p := range patterns {
+				dn, err := RenderBindDN(p, name)
+				if err != nil {
+					return err
+				}
+				candidates = append(candidates, PatternAttempt{Pattern: p, DN: dn})
+			}
+			res.UserDN = candidates[0].DN
+			return nil
+		})
+	}
+	if err != nil {
+		return
+	}
+
+	res.BindMethod = strings.ToLower(cfg.BindMethod)
+	if res.BindMethod == "" {
+		res.BindMethod = BindSimple
+	}
+	err = res.Phase(PhaseBind, func() error {
+		var pp *ldap.ControlBeheraPasswordPolicy
+		var err error
+		if len(candidates) > 1 && !isSASL(cfg.BindMethod) {
+			pp, err = bindPatterns(ctn, cfg, pass, candidates, res)
+		} else {
+			pp, err = BindUser(ctn, cfg, name, res.UserDN, pass)
+		}
+		res.Bind = NewBindOutcome(err)
+		if pp != nil {
+			res.Account = CheckAccount(NewPasswordPolicy(pp), nil, time.Now())
+		}
+		if err != nil {
+			return fmt.Errorf("connection denied: %w", err)
+		}
+
+		if isSASL(cfg.BindMethod) {
+			// SASL binds authenticate a name, ask the server which entry it maps to
+			if who, err := ctn.WhoAmI(nil); err == nil {
+				res.AuthzID = who.AuthzID
+				if dn, ok := strings.CutPrefix(who.AuthzID, "dn:"); ok && dn != "" {
+					res.UserDN = dn
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if res.UserDN == "" {
+		res.Phase(PhaseRead, func() error {
+			return fmt.Errorf("cannot read the user entry: the server maps the login to %q, not a DN; set bind_pattern or user_filter", res.AuthzID)
+		})
+		return
+	}
+
+	if cfg.Roles.configured() && len(cfg.Groups.Strategies) == 0 {
+		// roles need the groups, look them up the most common ways
+		cfg.Groups.Strategies = []string{StrategyMemberOf}
+		if cfg.Groups.Base != "" {
+			cfg.Groups.Strategies = append(cfg.Groups.Strategies, StrategyMember)
+		}
+	}
+
+	attrs := append(cfg.Attributes.Names(), adAccountAttributes...)
+	if cfg.Groups.uses(StrategyMemberOf) {
+		attrs = append(attrs, cfg.Groups.memberOfAttribute(cfg.Attributes))
+	}
+
+	var entry *ldap.Entry
+	err = res.Phase(PhaseRead, func() error {
+		userq := ldap.NewSearchRequest(
+			res.UserDN,
+			ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+			"(&)",
+			attrs,
+			nil,
+		)
+
+		sr, err := ctn.Search(userq)
+		if err != nil {
+			return fmt.Errorf("invalid user record in LDAP: contact your system administrator: %w", err)
+		}
+		if len(sr.Entries) != 1 {
+			return fmt.Errorf("invalid user record in LDAP: %d entries returned for %s", len(sr.Entries), res.UserDN)
+		}
+
+		entry = sr.Entries[0]
+		res.Attributes = make(map[string][]string)
+		for _, attr := range entry.Attributes {
+			res.Attributes[attr.Name] = append(res.Attributes[attr.Name], attr.Values...)
+		}
+
+		var pp *PasswordPolicy
+		if res.Account != nil {
+			pp = res.Account.PasswordPolicy
+		}
+		res.Account = CheckAccount(pp, entry, time.Now())
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = res.Phase(PhaseProfile, func() error {
+		res.Profile = CheckAttributes(cfg.Attributes, entry)
+		var bad []string
+		for _, v := range res.Profile {
+			if !v.OK {
+				bad = append(bad, v.Field+" ("+v.Attribute+")")
+			}
+		}
+		if len(bad) > 0 {
+			return fmt.Errorf("user profile incomplete: %s", strings.Join(bad, ", "))
+		}
+		return nil
+	})
+	if err != nil || len(cfg.Groups.Strategies) == 0 {
+		return
+	}
+
+	err = res.Phase(PhaseGroups, func() error {
+		res.Groups = ResolveGroups(ctn.Conn, cfg, name, entry)
+		var errs []string
+		for _, g := range res.Groups {
+			if g.Error != "" {
+				errs = append(errs, g.Strategy+": "+g.Error)
+			}
+		}
+		if len(errs) == len(res.Groups) {
+			return fmt.Errorf("no group strategy succeeded: %s", strings.Join(errs, "; "))
+		}
+		return nil
+	})
+	if err != nil || !cfg.Roles.configured() {
+		return
+	}
+
+	res.Phase(PhaseAuthorize, func() (err error) {
+		res.Role, err = DecideRole(ctn.Conn, cfg.Roles, res.UserDN, res.Groups)
+		if err != nil {
+			return err
+		}
+		if len(res.Role.Incomplete) > 0 {
+			return fmt.Errorf("role decision incomplete, group lookups failed: %s", strings.Join(res.Role.Incomplete, "; "))
+		}
+		if !res.Role.Allowed {
+			return fmt.Errorf("login not allowed: %s", res.Role.Reason)
+		}
+		return nil
+	})
+}
+
+// PatternAttempt is the outcome of binding with the DN of one bind pattern.
+type PatternAttempt struct {
+	Pattern string `json:"pattern"`
+	DN      string `json:"dn"`
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// bindPatterns binds with each candidate DN in turn, moving on to the next one only while the entry does not exist:
+// a wrong password stops early rather than adding failed logins to the account.
+func bindPatterns(ctn *Conn, cfg LDAPConfig, pass string, candidates []PatternAttempt, res *Result) (*ldap.ControlBeheraPasswordPolicy, error) {
+	for i := range candidates {
+		c := &candidates[i]
+		res.UserDN, res.Patterns = c.DN, candidates[:i+1]
+		pp, err := BindUser(ctn, cfg, "", c.DN, pass)
+		if err == nil {
+			c.Outcome = "success"
+			return pp, nil
+		}
+
+		state, why := lookupEntry(ctn, cfg, c.DN, err)
+		c.Outcome = resultCodeName(err) + ", " + why
+		switch {
+		case state == entryExists:
+			return pp, err
+		case state == entryUnknown:
+			return pp, fmt.Errorf("cannot tell whether %s exists, the other bind patterns are not tried with the same password: %w", c.DN, err)
+		case i == len(candidates)-1:
+			return pp, fmt.Errorf("no bind pattern designates an existing entry: %w", err)
+		}
+	}
+	return nil, nil
+}
+
+// What is known of the entry a bind failed with
+const (
+	entryExists = iota
+	entryMissing
+	entryUnknown
+)
+
+// lookupEntry tells whether a failed bind is due to the DN not existing.
+// Many servers answer invalid credentials for unknown DNs, so the entry is looked up to find out.
+func lookupEntry(ctn *Conn, cfg LDAPConfig, dn string, err error) (int, string) {
+	var lerr *ldap.Error
+	if !errors.As(err, &lerr) {
+		return entryExists, "stopping"
+	}
+	switch lerr.ResultCode {
+	case ldap.LDAPResultNoSuchObject:
+		return entryMissing, "no such entry"
+	case ldap.LDAPResultInvalidCredentials:
+	default:
+		return entryExists, "stopping"
+	}
+	if d := DecodeADBindError(lerr.Error()); d != nil {
+		if d.Code == "525" {
+			return entryMissing, "no such user"
+		}
+		if d.Unusable {
+			return entryExists, "the password is right, stopping"
+		}
+	}
+
+	// the failed bind left the connection anonymous
+	if err := bindService(ctn.Conn, cfg); err != nil {
+		return entryUnknown, "cannot look the entry up, the service account is denied, stopping"
+	}
+	_, serr := ctn.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"1.1"}, nil))
+	switch {
+	case serr == nil:
+		return entryExists, "the entry exists, wrong password, stopping"
+	case ldap.IsErrorWithCode(serr, ldap.LDAPResultNoSuchObject):
+		return entryMissing, "no such entry"
+	}
+	return entryUnknown, "cannot tell whether the entry exists, stopping"
+}
+
+// RenderBindDN fills the bind pattern template with the user name, escaped as a DN attribute value (RFC 4514).
+// User principal names and down-level (DOMAIN\user) patterns are not escaped.
+func RenderBindDN(pattern, name string) (string, error) {
+	escape := func(s string) string { return s }
+	if isDNPattern(pattern) {
+		escape = EscapeDN
+	}
+	dn, err := renderPattern("bind_pattern", pattern, name, escape)
+	if err != nil {
+		return "", fmt.Errorf("invalid bind pattern, no access will ever match: %w", err)
+	}
+	return dn, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func testDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		Entries: map[string]map[string][]string{
+			"uid=jdoe,ou=people,dc=example,dc=com": {
+				"uid": {"jdoe"}, "displayName": {"John Doe"}, "mail": {"jdoe@example.com"},
+			},
+			"uid=locked,ou=people,dc=example,dc=com":  {"uid": {"locked"}, "mail": {"dup@example.com"}},
+			"uid=dup,ou=staff,dc=example,dc=com":      {"uid": {"dup"}, "mail": {"dup@example.com"}},
+			"uid=dup,ou=people,dc=example,dc=com":     {"uid": {"dup"}},
+			"cn=svc,dc=example,dc=com":                {"cn": {"svc"}},
+			"uid=expired,ou=people,dc=example,dc=com": {"uid": {"expired"}},
+		},
+		Passwords: map[string]string{
+			"uid=jdoe,ou=people,dc=example,dc=com": "secret",
+			"cn=svc,dc=example,dc=com":             "svcpass",
+		},
+		BindResult: map[string]fakeResult{
+			"uid=locked,ou=people,dc=example,dc=com": {Code: ldap.LDAPResultUnwillingToPerform, Message: "account locked"},
+			"uid=expired,ou=people,dc=example,dc=com": {Code: ldap.LDAPResultInvalidCredentials,
+				Message: "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 532, v4563"},
+		},
+	}
+}
+
+func TestLoginExitCodes(t *testing.T) {
+	url := startFakeDirectory(t, testDirectory())
+	pattern := LDAPConfig{ServerURL: url, BindUserPattern: "uid={{.UserName}},ou=people,dc=example,dc=com"}
+	search := LDAPConfig{
+		ServerURL:      url,
+		BindDN:         "cn=svc,dc=example,dc=com",
+		BindPassword:   "svcpass",
+		UserSearchBase: "dc=example,dc=com",
+		UserFilter:     "(uid={{.UserName}})",
+	}
+
+	for _, c := range []struct {
+		name       string
+		cfg        LDAPConfig
+		user, pass string
+		exit       int
+	}{
+		{"pattern", pattern, "jdoe", "secret", ExitOK},
+		{"wrong password", pattern, "jdoe", "nope", ExitInvalidCredentials},
+		{"locked", pattern, "locked", "secret", ExitAccountUnusable},
+		{"ad password expired", pattern, "expired", "secret", ExitAccountUnusable},
+		{"search", search, "jdoe", "secret", ExitOK},
+		{"not found", search, "nobody", "secret", ExitSearch},
+		{"ambiguous", search, "dup", "secret", ExitSearch},
+		{"unreachable", LDAPConfig{ServerURL: "ldap://127.0.0.1:1", BindUserPattern: "uid={{.UserName}}"}, "jdoe", "secret", ExitNetwork},
+		{"bad template", LDAPConfig{ServerURL: url, BindUserPattern: "uid={{.UserName"}, "jdoe", "secret", ExitConfig},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			res := TestLoginWithLDAP(c.cfg, c.user, c.pass)
+			if got := ExitCode(res); got != c.exit {
+				t.Errorf("exit code = %d, want %d (error: %v)", got, c.exit, res.Err)
+			}
+		})
+	}
+
+	res := TestLoginWithLDAP(search, "dup", "secret")
+	if !errors.Is(res.Err, ErrMultipleUsers) {
+		t.Errorf("ambiguous user: got error %v", res.Err)
+	}
+	res = TestLoginWithLDAP(pattern, "jdoe", "secret")
+	if got := res.Attributes["mail"]; len(got) != 1 || got[0] != "jdoe@example.com" {
+		t.Errorf("mail attribute = %v", got)
+	}
+}
+
+// OpenLDAP ppolicy tells locked accounts and expired passwords apart from wrong passwords with a response control.
+func TestPasswordPolicy(t *testing.T) {
+	dir := testDirectory()
+	dir.Entries["uid=pplocked,ou=people,dc=example,dc=com"] = map[string][]string{"uid": {"pplocked"}}
+	dir.Entries["uid=expiring,ou=people,dc=example,dc=com"] = map[string][]string{"uid": {"expiring"}, "displayName": {"Expiring"}, "mail": {"expiring@example.com"}}
+	dir.BindResult["uid=pplocked,ou=people,dc=example,dc=com"] = fakeResult{Code: ldap.LDAPResultInvalidCredentials,
+		Controls: []*ber.Packet{ppolicyControl(-1, ldap.BeheraAccountLocked)}}
+	dir.BindResult["uid=expiring,ou=people,dc=example,dc=com"] = fakeResult{
+		Controls: []*ber.Packet{ppolicyControl(3600, -1)}}
+	cfg := LDAPConfig{ServerURL: startFakeDirectory(t, dir), BindUserPattern: "uid={{.UserName}},ou=people,dc=example,dc=com"}
+
+	res := TestLoginWithLDAP(cfg, "pplocked", "secret")
+	if ExitCode(res) != ExitAccountUnusable {
+		t.Errorf("locked: exit code %d (error: %v)", ExitCode(res), res.Err)
+	}
+	if res.Account == nil || res.Account.PasswordPolicy == nil || res.Account.PasswordPolicy.Error != "Account locked" {
+		t.Errorf("locked: account %+v", res.Account)
+	}
+
+	res = TestLoginWithLDAP(cfg, "expiring", "secret")
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if pp := res.Account.PasswordPolicy; pp == nil || pp.ExpiresIn == nil || *pp.ExpiresIn != 3600 || pp.Error != "" {
+		t.Errorf("expiring: password policy %+v", pp)
+	}
+	if len(res.Account.Warnings) == 0 {
+		t.Error("expiring: no warning")
+	}
+}
+
+func TestSplitDomainUser(t *testing.T) {
+	for _, c := range []struct{ name, domain, user string }{
+		{`CORP\jdoe`, "CORP", "jdoe"},
+		{"jdoe@corp.example.com", "", "jdoe@corp.example.com"},
+		{"jdoe", "DEFAULT", "jdoe"},
+	} {
+		d, u := SplitDomainUser(c.name, "DEFAULT")
+		if d != c.domain || u != c.user {
+			t.Errorf("SplitDomainUser(%q) = %q, %q, want %q, %q", c.name, d, u, c.domain, c.user)
+		}
+	}
+}
+
+func TestLoginFailover(t *testing.T) {
+	cfg := LDAPConfig{
+		ServerURL:       "ldap://127.0.0.1:1",
+		ServerURLs:      []string{startFakeDirectory(t, testDirectory())},
+		BindUserPattern: "uid={{.UserName}},ou=people,dc=example,dc=com",
+		Retry:           RetryConfig{Attempts: 2, Backoff: time.Millisecond},
+	}
+	res := TestLoginWithLDAP(cfg, "jdoe", "secret")
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if len(res.Servers) != 2 || res.Servers[0].Attempts != 2 || res.Servers[0].Error == "" || res.Servers[1].Error != "" {
+		t.Errorf("servers = %+v", res.Servers)
+	}
+	if res.URL != cfg.ServerURLs[0] {
+		t.Errorf("url = %s, want the second server", res.URL)
+	}
+}
+
+func TestBindPatterns(t *testing.T) {
+	cfg := LDAPConfig{
+		ServerURL: startFakeDirectory(t, testDirectory()),
+		BindPatterns: []string{
+			"uid={{.UserName}},ou=contractors,dc=example,dc=com",
+			"uid={{.UserName}},ou=people,dc=example,dc=com",
+			"uid={{.UserName}},ou=staff,dc=example,dc=com",
+		},
+	}
+	res := TestLoginWithLDAP(cfg, "jdoe", "secret")
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if len(res.Patterns) != 2 || res.Patterns[1].Outcome != "success" || res.UserDN != "uid=jdoe,ou=people,dc=example,dc=com" {
+		t.Errorf("patterns %+v, user DN %s", res.Patterns, res.UserDN)
+	}
+
+	// the entry exists, the other patterns are not tried
+	res = TestLoginWithLDAP(cfg, "dup", "wrong")
+	if ExitCode(res) != ExitInvalidCredentials || len(res.Patterns) != 2 {
+		t.Errorf("wrong password: exit code %d, patterns %+v", ExitCode(res), res.Patterns)
+	}
+
+	res = TestLoginWithLDAP(cfg, "nobody", "secret")
+	if ExitCode(res) != ExitInvalidCredentials || len(res.Patterns) != 3 || !strings.Contains(res.Error, "no bind pattern designates an existing entry") {
+		t.Errorf("unknown user: exit code %d, patterns %+v: %s", ExitCode(res), res.Patterns, res.Error)
+	}
+
+	// when the entry cannot be looked up, the password is not sent to the other patterns
+	dir := testDirectory()
+	dir.DenyAnonymous = true
+	cfg.ServerURL = startFakeDirectory(t, dir)
+	for _, c := range []struct{ bindDN, bindPassword string }{{"", ""}, {"cn=svc,dc=example,dc=com", "stale"}} {
+		cfg.BindDN, cfg.BindPassword = c.bindDN, c.bindPassword
+		res = TestLoginWithLDAP(cfg, "dup", "wrong")
+		if ExitCode(res) != ExitInvalidCredentials || len(res.Patterns) != 1 || !strings.Contains(res.Error, "cannot tell whether") {
+			t.Errorf("bind_dn %q: exit code %d, patterns %+v: %s", c.bindDN, ExitCode(res), res.Patterns, res.Error)
+		}
+	}
+
+	// the service account can look the entries up
+	cfg.BindPassword = "svcpass"
+	res = TestLoginWithLDAP(cfg, "jdoe", "secret")
+	if res.Err != nil || len(res.Patterns) != 2 {
+		t.Errorf("with bind_dn: patterns %+v: %v", res.Patterns, res.Err)
+	}
+}
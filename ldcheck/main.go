@@ -1,141 +1,361 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"net"
-	"net/url"
 	"os"
-	"strings"
-	"text/template"
-
-	"crypto/tls"
+	"time"
 
 	"github.com/BurntSushi/toml"
-	"github.com/go-ldap/ldap/v3"
-	"golang.org/x/term"
 )
 
-func TestLoginWithLDAP(url, query, name, pass string) {
-	tpl, err := template.New("ldap").Parse(query)
-	if err != nil {
-		log.Fatal(err, "invalid bind pattern, no access will ever match")
-	}
+func main() {
+	var (
+		conf   = flag.String("file", "ldap.local.toml", "Configuration file to check")
+		name   = flag.String("name", "johndoe", "User Name")
+		format = flag.String("format", "text", "Output format: text or json")
+		pass   = PasswordSource{FD: -1}
 
-	ctn, err := DialURL(url)
-	if err != nil {
-		log.Fatal(err, "cannot contact LDAP server")
-	}
+		gcCompare  = flag.Bool("gc-compare", false, "After the login check, compare the user entry through the domain controller and the global catalog")
+		gcURL      = flag.String("gc-url", "", "In gc-compare mode, the other server, by default the global catalog (or domain controller) on the same host")
+		allServers = flag.Bool("all-servers", false, "Check the login against every server of server_urls instead of failing over")
 
-	var userdn strings.Builder
-	if err := tpl.Execute(&userdn, struct{ UserName string }{ldap.EscapeFilter(name)}); err != nil {
-		log.Fatal(err, "invalid LDAP query pattern")
-		return
-	}
-	fmt.Println("checking user DN:", userdn.String())
+		batch       = flag.String("batch", "", "Check every username,password row of a CSV file instead of -name")
+		shared      = flag.Bool("shared-conn", false, "In batch mode, check all users over a single connection")
+		concurrency = flag.Int("concurrency", 4, "In batch mode, number of users checked in parallel on separate connections")
 
-	if err := ctn.Bind(userdn.String(), pass); err != nil {
-		log.Fatal(err, "connection denied")
-		return
-	}
+		bench     = flag.Bool("bench", false, "Measure bind throughput and latency with concurrent connections, then exit")
+		benchOpts = BenchOptions{}
 
-	userq := ldap.NewSearchRequest(
-		userdn.String(),
-		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
-		"(&)",
-		[]string{"displayName", "mail"}, // A list attributes to retrieve
-		nil,
+		lint      = flag.Bool("lint", false, "Validate the configuration file without contacting the server, then exit")
+		tlsReport = flag.Bool("tls-report", false, "Print the TLS handshake details and certificate chain, then exit")
+		tlsScan   = flag.Bool("tls-scan", false, "Print the TLS versions and cipher suites accepted by the server, then exit")
+		probe     = flag.Bool("probe", false, "Print the server RootDSE and the features it supports, then exit")
+		enumerate = flag.Bool("enumerate", false, "Count the users and groups, and the users with an incomplete profile, then exit")
+		pageSize  = flag.Uint("page-size", 500, "In enumerate mode, number of entries read per page")
+		schema    = flag.Bool("schema", false, "Check the configured attributes and object classes against the server schema, then exit")
 	)
+	flag.StringVar(&pass.Value, "pass", "", "Password (ends up in shell history, prefer the other sources)")
+	flag.StringVar(&pass.File, "pass-file", "", "Read the password from the first line of a file")
+	flag.StringVar(&pass.Env, "pass-env", "", "Read the password from an environment variable")
+	flag.IntVar(&pass.FD, "pass-fd", -1, "Read the password from an inherited file descriptor")
+	flag.IntVar(&benchOpts.Conns, "bench-conns", 4, "In bench mode, number of concurrent connections")
+	flag.DurationVar(&benchOpts.Duration, "bench-duration", 10*time.Second, "In bench mode, how long to run")
+	flag.IntVar(&benchOpts.Count, "bench-count", 0, "In bench mode, total number of binds, overrides -bench-duration")
+	flag.BoolVar(&benchOpts.Search, "bench-search", false, "In bench mode, read the user attributes after each bind")
+	flag.BoolVar(&benchOpts.Reconnect, "bench-reconnect", false, "In bench mode, open a new connection for each bind")
+	flag.IntVar(&benchOpts.MaxFailures, "bench-max-failures", 3, "In bench mode, stop after that many failed binds to protect the account")
+	flag.Usage = usageWithExitCodes
+	flag.Parse()
+
+	if *format != "text" && *format != "json" {
+		fatal(ExitUsage, fmt.Errorf("invalid format %q: want text or json", *format))
+	}
+
+	// with -format json, failures before the checks still print a result, failed in the config phase
+	configFailed := func(err error) {
+		if *format != "json" {
+			fatal(ExitConfig, err)
+		}
+		res := &Result{UserName: *name}
+		res.Phase(PhaseConfig, func() error { return err })
+		if err := res.WriteJSON(os.Stdout); err != nil {
+			fatal(ExitFailure, err)
+		}
+		os.Exit(ExitCode(res))
+	}
 
-	sr, err := ctn.Search(userq)
+	var Config Config
+	md, err := toml.DecodeFile(*conf, &Config)
 	if err != nil {
-		log.Fatal(err, "invalid user record in LDAP: contact your system administrator")
+		configFailed(err)
+	}
+
+	if *lint {
+		findings := Lint(md, Config.LDAP)
+		WriteFindings(os.Stdout, findings)
+		if HasErrors(findings) {
+			os.Exit(ExitConfig)
+		}
 		return
 	}
 
-	for _, entry := range sr.Entries {
-		entry.PrettyPrint(2)
+	if Config.LDAP.TLS.InsecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "WARNING: insecure_skip_verify is set, the server certificate is NOT verified.")
+		fmt.Fprintln(os.Stderr, "WARNING: anybody on the network path can intercept the connection and the password.")
 	}
-}
 
-func readPassword() (s []byte, err error) {
-	if term.IsTerminal(int(os.Stdin.Fd())) {
-		fmt.Fprint(os.Stdin, "password ")
-		return term.ReadPassword(int(os.Stdin.Fd()))
+	if *tlsReport {
+		dialer, err := NewDialer(Config.LDAP)
+		if err != nil {
+			configFailed(err)
+		}
+		os.Exit(forEachServer(dialer, Config.LDAP, func(url string) error {
+			return dialer.WriteTLSReport(os.Stdout, url)
+		}))
 	}
-	panic("not implemented")
-}
 
-var allowInsecure bool
-var allowVeryInsecure bool
+	if *tlsScan {
+		dialer, err := NewDialer(Config.LDAP)
+		if err != nil {
+			configFailed(err)
+		}
+		os.Exit(forEachServer(dialer, Config.LDAP, func(url string) error {
+			scan, err := dialer.ScanTLS(url)
+			if err != nil {
+				return err
+			}
+			scan.WriteMatrix(os.Stdout)
+			return nil
+		}))
+	}
 
-func main() {
-	var (
-		conf = flag.String("file", "ldap.local.toml", "Configuration file to check")
-		name = flag.String("name", "johndoe", "User Name")
-		pass = flag.String("pass", "correcthorsebatterystaple", "Password")
-	)
-	flag.BoolVar(&allowInsecure, "tlsv1", false, "Allow TLSv1 connection")
-	flag.BoolVar(&allowVeryInsecure, "ssl3", false, "Allow SSLv3 connection")
-	flag.Parse()
+	if *probe {
+		dialer, err := NewDialer(Config.LDAP)
+		if err != nil {
+			configFailed(err)
+		}
+		ctn, _, err := dialer.DialServers(Config.LDAP.Servers())
+		if err != nil {
+			fatal(connectExit(err), err)
+		}
+		dse, err := ReadRootDSE(ctn.Conn, Config.LDAP)
+		ctn.Close()
+		if err != nil {
+			fatal(ExitSearch, err)
+		}
+		if *format == "json" {
+			err = dse.WriteJSON(os.Stdout)
+		} else {
+			err = dse.WriteText(os.Stdout)
+		}
+		if err != nil {
+			fatal(ExitFailure, err)
+		}
+		return
+	}
 
-	var Config struct {
-		LDAP struct {
-			ServerURL       string `toml:"server_url"`
-			BindUserPattern string `toml:"bind_pattern"`
+	if *schema {
+		dialer, err := NewDialer(Config.LDAP)
+		if err != nil {
+			configFailed(err)
 		}
+		ctn, _, err := dialer.DialServers(Config.LDAP.Servers())
+		if err != nil {
+			fatal(connectExit(err), err)
+		}
+		sch, err := ReadSchema(ctn.Conn, Config.LDAP)
+		ctn.Close()
+		if err != nil {
+			fatal(ExitSearch, err)
+		}
+		findings := CheckSchema(sch, Config.LDAP)
+		WriteFindings(os.Stdout, findings)
+		if HasErrors(findings) {
+			os.Exit(ExitConfig)
+		}
+		return
 	}
-	_, err := toml.DecodeFile(*conf, &Config)
-	if err != nil {
-		log.Fatal(err)
+
+	if *enumerate {
+		dialer, err := NewDialer(Config.LDAP)
+		if err != nil {
+			configFailed(err)
+		}
+		ctn, _, err := dialer.DialServers(Config.LDAP.Servers())
+		if err != nil {
+			fatal(connectExit(err), err)
+		}
+		en, err := Enumerate(ctn.Conn, Config.LDAP, uint32(*pageSize))
+		ctn.Close()
+		if err != nil {
+			fatal(ExitSearch, err)
+		}
+		if *format == "json" {
+			err = en.WriteJSON(os.Stdout)
+		} else {
+			err = en.WriteText(os.Stdout)
+		}
+		if err != nil {
+			fatal(ExitFailure, err)
+		}
+		if en.Incomplete > 0 || len(en.DuplicateIDs) > 0 {
+			os.Exit(ExitFailure)
+		}
+		return
 	}
 
-	TestLoginWithLDAP(Config.LDAP.ServerURL, Config.LDAP.BindUserPattern, *name, *pass)
-}
+	if *batch != "" {
+		fh, err := os.Open(*batch)
+		if err != nil {
+			fatal(ExitUsage, err)
+		}
+		creds, err := ReadCredentials(fh, func(name string) (string, error) {
+			return promptPassword("password for " + name + ": ")
+		})
+		fh.Close()
+		if err != nil {
+			fatal(ExitUsage, fmt.Errorf("reading %s: %w", *batch, err))
+		}
+		if len(creds) == 0 {
+			fatal(ExitUsage, fmt.Errorf("no user in %s", *batch))
+		}
 
-func DialURL(addr string) (*ldap.Conn, error) {
-	lurl, err := url.Parse(addr)
-	if err != nil {
-		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+		results := CheckBatch(Config.LDAP, creds, *shared, *concurrency)
+		if *format == "json" {
+			err = WriteBatchJSON(os.Stdout, results)
+		} else {
+			err = WriteBatchText(os.Stdout, results)
+		}
+		if err != nil {
+			fatal(ExitFailure, err)
+		}
+		os.Exit(BatchExitCode(results))
 	}
 
-	host, port, err := net.SplitHostPort(lurl.Host)
+	password, err := pass.Read()
 	if err != nil {
-		// we asume that error is due to missing port
-		host = lurl.Host
-		port = ""
+		configFailed(err)
 	}
-
-	switch lurl.Scheme {
-	case "ldapi":
-		if lurl.Path == "" || lurl.Path == "/" {
-			lurl.Path = "/var/run/slapd/ldapi"
+	cfg := Config.LDAP
+	var servers []ServerAttempt
+	if *allServers {
+		// no failover, each server, including the discovered ones, is checked on its own
+		dialer, err := NewDialer(cfg)
+		if err != nil {
+			configFailed(err)
 		}
-		return ldap.Dial("unix", lurl.Path)
-	case "ldap":
-		if port == "" {
-			port = ldap.DefaultLdapPort
+		if servers = dialer.Discover(cfg.Servers()); len(servers) == 0 {
+			configFailed(errors.New("no server configured"))
 		}
-		return ldap.Dial("tcp", net.JoinHostPort(host, port))
-	case "ldaps":
-		if port == "" {
-			port = ldap.DefaultLdapsPort
+		cfg.ServerURL, cfg.ServerURLs, cfg.Domain = servers[0].URL, nil, ""
+	}
+	res := TestLoginWithLDAP(cfg, *name, password)
+	if *bench && res.Err == nil {
+		dialer, err := NewDialer(Config.LDAP)
+		if err != nil {
+			fatal(ExitConfig, err)
 		}
-		minversion := tls.VersionTLS12 // default for clients
-		if allowInsecure {
-			minversion = tls.VersionTLS10
+		cfg.ServerURL = res.URL // the server the login check reached
+		stats := Bench(dialer, cfg, *name, res.UserDN, password, benchOpts)
+		if *format == "json" {
+			err = stats.WriteJSON(os.Stdout)
+		} else {
+			err = stats.WriteText(os.Stdout)
 		}
-		if allowVeryInsecure {
-			minversion = tls.VersionSSL30
+		if err != nil {
+			fatal(ExitFailure, err)
 		}
-
-		tlsConf := &tls.Config{
-			ServerName: host,
-			MinVersion: uint16(minversion),
+		switch {
+		case stats.Binds == 0 && stats.DialFailures > 0:
+			os.Exit(ExitNetwork)
+		case stats.Failures > 0 || stats.DialFailures > 0:
+			os.Exit(ExitFailure)
+		}
+		return
+	}
+	if *bench {
+		fmt.Fprintln(os.Stderr, "login check failed, not benchmarking with these credentials")
+	}
+	if *gcCompare && res.Err == nil {
+		other := *gcURL
+		if other == "" {
+			if other, err = CounterpartURL(res.URL); err != nil {
+				fatal(ExitUsage, err)
+			}
+		}
+		domain, gc := res.URL, other
+		if res.GC {
+			domain, gc = other, res.URL
+		}
+		dialer, err := NewDialer(Config.LDAP)
+		if err != nil {
+			fatal(ExitConfig, err)
+		}
+		cmp, err := CompareGC(dialer, Config.LDAP, domain, gc, *name, res.UserDN, password)
+		if err != nil {
+			fatal(ExitSearch, err)
+		}
+		if *format == "json" {
+			err = cmp.WriteJSON(os.Stdout)
+		} else {
+			err = cmp.WriteText(os.Stdout)
+		}
+		if err != nil {
+			fatal(ExitFailure, err)
 		}
-		return ldap.DialTLS("tcp", net.JoinHostPort(host, port), tlsConf)
+		if !cmp.ProfileOK() {
+			os.Exit(ExitFailure)
+		}
+		return
+	}
+	if *gcCompare {
+		fmt.Fprintln(os.Stderr, "login check failed, not comparing with the global catalog")
 	}
+	if *allServers {
+		results := []*Result{res}
+		for _, at := range servers[1:] {
+			cfg.ServerURL = at.URL
+			if at.Error != "" {
+				r := &Result{URL: at.URL, UserName: *name}
+				r.Phase(PhaseDial, func() error { return errors.New(at.Error) })
+				results = append(results, r)
+				continue
+			}
+			results = append(results, TestLoginWithLDAP(cfg, *name, password))
+		}
+		if *format == "json" {
+			err = WriteBatchJSON(os.Stdout, results)
+		} else {
+			for i, r := range results {
+				if i > 0 {
+					fmt.Println()
+				}
+				if err = r.WriteText(os.Stdout); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			fatal(ExitFailure, err)
+		}
+		os.Exit(BatchExitCode(results))
+	}
+	switch *format {
+	case "json":
+		err = res.WriteJSON(os.Stdout)
+	default:
+		err = res.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fatal(ExitFailure, err)
+	}
+	os.Exit(ExitCode(res))
+}
 
-	return nil, ldap.NewError(ldap.ErrorNetwork, fmt.Errorf("Unknown scheme '%s'", lurl.Scheme))
+// forEachServer calls f with each configured or discovered server, printing a header when there are several.
+// It returns the exit code of the last failure.
+func forEachServer(d *Dialer, cfg LDAPConfig, f func(url string) error) int {
+	code := ExitOK
+	servers := d.Discover(cfg.Servers())
+	for i, at := range servers {
+		if len(servers) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Println("==", at.URL)
+		}
+		if at.Error != "" {
+			log.Print(at.Error)
+			code = ExitNetwork
+			continue
+		}
+		if err := f(at.URL); err != nil {
+			log.Print(err)
+			code = connectExit(err)
+		}
+	}
+	return code
 }
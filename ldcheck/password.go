@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// PasswordSource lists the places the user password can come from.
+// At most one can be set; without any, the password is prompted on a terminal or read from piped stdin.
+type PasswordSource struct {
+	Value string
+	File  string
+	Env   string
+	FD    int // negative when unset
+}
+
+func (s PasswordSource) Read() (string, error) {
+	set := 0
+	for _, ok := range []bool{s.Value != "", s.File != "", s.Env != "", s.FD >= 0} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", errors.New("-pass, -pass-file, -pass-env and -pass-fd are mutually exclusive")
+	}
+
+	switch {
+	case s.Value != "":
+		return s.Value, nil
+	case s.File != "":
+		fh, err := os.Open(s.File)
+		if err != nil {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		defer fh.Close()
+		return readLine(fh)
+	case s.Env != "":
+		pw, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("reading password: environment variable %s is not set", s.Env)
+		}
+		return pw, nil
+	case s.FD >= 0:
+		fh := os.NewFile(uintptr(s.FD), "password-fd")
+		if fh == nil {
+			return "", fmt.Errorf("reading password: invalid file descriptor %d", s.FD)
+		}
+		defer fh.Close()
+		return readLine(fh)
+	}
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return promptPassword("password: ")
+	}
+	return readLine(os.Stdin)
+}
+
+// promptPassword reads a password from the terminal without echo.
+func promptPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	pw, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	return string(pw), nil
+}
+
+func readLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordSource(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pass")
+	if err := os.WriteFile(file, []byte("from file\r\nsecond line\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LDCHECK_TEST_PASS", "from env")
+
+	for _, c := range []struct {
+		name string
+		src  PasswordSource
+		want string
+	}{
+		{"flag", PasswordSource{Value: "from flag", FD: -1}, "from flag"},
+		{"file", PasswordSource{File: file, FD: -1}, "from file"},
+		{"env", PasswordSource{Env: "LDCHECK_TEST_PASS", FD: -1}, "from env"},
+	} {
+		got, err := c.src.Read()
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		} else if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	if _, err := (PasswordSource{Value: "x", Env: "LDCHECK_TEST_PASS", FD: -1}).Read(); err == nil {
+		t.Error("several sources accepted")
+	}
+	if _, err := (PasswordSource{Env: "LDCHECK_TEST_UNSET", FD: -1}).Read(); err == nil {
+		t.Error("unset environment variable accepted")
+	}
+}
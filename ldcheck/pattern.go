@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// helpers available in bind patterns and user filters, e.g. uid={{userFromUPN .UserName | lower}},ou=people
+var patternFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"userFromUPN": func(s string) string {
+		if i := strings.LastIndexByte(s, '@'); i >= 0 {
+			return s[:i]
+		}
+		return s
+	},
+	"domainFromUPN": func(s string) string {
+		if i := strings.LastIndexByte(s, '@'); i >= 0 {
+			return s[i+1:]
+		}
+		return ""
+	},
+	"netbios": func(s string) string {
+		d, _ := SplitDomainUser(s, "")
+		return d
+	},
+	"userFromNetbios": func(s string) string {
+		_, u := SplitDomainUser(s, "")
+		return u
+	},
+}
+
+// renderPattern executes the template with the raw user name, escaping the output of every action.
+// Escaping last lets the helpers work on the name as typed, and covers any way the name reaches the output.
+func renderPattern(name, pattern, user string, escape func(string) string) (string, error) {
+	funcs := template.FuncMap{"escape": escape}
+	for k, f := range patternFuncs {
+		funcs[k] = f
+	}
+	tpl, err := template.New(name).Funcs(funcs).Parse(pattern)
+	if err != nil {
+		return "", err
+	}
+	escapeActions(tpl.Tree.Root)
+
+	var b strings.Builder
+	if err := tpl.Execute(&b, struct{ UserName string }{user}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// escapeActions appends the escape function to the pipeline of every action, as html/template does.
+func escapeActions(n parse.Node) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			escapeActions(c)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) == 0 {
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Args:     []parse.Node{parse.NewIdentifier("escape").SetTree(nil).SetPos(n.Pos)},
+			})
+		}
+	case *parse.IfNode:
+		escapeActions(n.List)
+		escapeActions(n.ElseList)
+	case *parse.RangeNode:
+		escapeActions(n.List)
+		escapeActions(n.ElseList)
+	case *parse.WithNode:
+		escapeActions(n.List)
+		escapeActions(n.ElseList)
+	}
+}
+
+// EscapeDN escapes an attribute value for a DN string (RFC 4514, section 2.4).
+func EscapeDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == 0:
+			b.WriteString(`\00`)
+		case strings.IndexByte(`"+,;<>\=`, c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(s)-1 && c == ' ':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// isDNPattern tells DN bind patterns from user principal and down-level (DOMAIN\user) names, which are not escaped.
+func isDNPattern(pattern string) bool { return strings.Contains(pattern, "=") }
+
+// names trying to break out of the value they are inserted in
+var injectionSamples = []string{
+	"jdoe,ou=admins", "jdoe+uid=admin", "jdoe=admin", `jdoe\`, `"jdoe"`, "#jdoe", " jdoe ", "jdoe;cn=admin",
+	"<jdoe>", "jdoe\x00", "*", "jdoe)(uid=*", "*)(|(uid=*",
+}
+
+// InjectionResult is what an adversarial user name renders to.
+type InjectionResult struct {
+	UserName string
+	Rendered string
+	Safe     bool // the name stayed within its value
+	Error    error
+}
+
+// CheckInjection renders the adversarial names, comparing the structure of each DN or filter with the one of a plain name.
+func CheckInjection(pattern string, filter bool) []InjectionResult {
+	render, shape := RenderBindDN, dnShape
+	if filter {
+		render, shape = RenderUserFilter, filterShape
+	}
+	plain, err := render(pattern, "jdoe")
+	if err != nil {
+		return []InjectionResult{{UserName: "jdoe", Error: err}}
+	}
+	want, err := shape(plain)
+	if err != nil {
+		return []InjectionResult{{UserName: "jdoe", Rendered: plain, Error: err}}
+	}
+
+	var rs []InjectionResult
+	for _, name := range injectionSamples {
+		r := InjectionResult{UserName: name}
+		if r.Rendered, r.Error = render(pattern, name); r.Error == nil {
+			var got string
+			got, r.Error = shape(r.Rendered)
+			r.Safe = r.Error == nil && got == want
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// dnShape describes a DN without the values of the user RDN.
+func dnShape(s string) (string, error) {
+	dn, err := ldap.ParseDN(s)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, rdn := range dn.RDNs {
+		for i, a := range rdn.Attributes {
+			if i > 0 {
+				b.WriteByte('+')
+			}
+			b.WriteString(strings.ToLower(a.Type))
+		}
+		b.WriteByte(',')
+	}
+	return b.String(), nil
+}
+
+// filterShape describes a filter without its assertion values.
+func filterShape(s string) (string, error) {
+	p, err := ldap.CompileFilter(s)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	var walk func(p *ber.Packet)
+	walk = func(p *ber.Packet) {
+		fmt.Fprintf(&b, "(%d", p.Tag)
+		switch p.Tag {
+		case ldap.FilterAnd, ldap.FilterOr, ldap.FilterNot:
+			for _, c := range p.Children {
+				walk(c)
+			}
+		case ldap.FilterPresent:
+			b.WriteString(strings.ToLower(p.Data.String()))
+		case ldap.FilterSubstrings:
+			fmt.Fprintf(&b, "%s:%d", strings.ToLower(p.Children[0].Data.String()), len(p.Children[1].Children))
+		case ldap.FilterExtensibleMatch:
+			fmt.Fprintf(&b, ":%d", len(p.Children))
+		default:
+			b.WriteString(strings.ToLower(p.Children[0].Data.String()))
+		}
+		b.WriteByte(')')
+	}
+	walk(p)
+	return b.String(), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestEscapeDN(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"jdoe", "jdoe"},
+		{"smith, john", `smith\, john`},
+		{"a+cn=admin", `a\+cn\=admin`},
+		{`"q"<x>;\`, `\"q\"\<x\>\;\\`},
+		{"#x#", `\#x#`},
+		{" jdoe ", `\ jdoe\ `},
+		{"a b", "a b"},
+		{"nul\x00", `nul\00`},
+		{"é", "é"},
+	} {
+		if got := EscapeDN(c.in); got != c.want {
+			t.Errorf("EscapeDN(%q) = %s, want %s", c.in, got, c.want)
+		}
+	}
+
+	for _, name := range injectionSamples {
+		dn, err := ldap.ParseDN("uid=" + EscapeDN(name) + ",dc=example,dc=com")
+		if err != nil {
+			t.Errorf("%q: %s", name, err)
+			continue
+		}
+		if len(dn.RDNs) != 3 || dn.RDNs[0].Attributes[0].Value != name {
+			t.Errorf("%q: parsed back as %v", name, dn.RDNs[0].Attributes[0])
+		}
+	}
+}
+
+func TestPatternHelpers(t *testing.T) {
+	for _, c := range []struct{ pattern, name, want string }{
+		{"uid={{lower .UserName}},dc=example,dc=com", "JDoe", "uid=jdoe,dc=example,dc=com"},
+		{"{{userFromUPN .UserName | upper}}@CORP.EXAMPLE.COM", "jdoe@example.com", "JDOE@CORP.EXAMPLE.COM"},
+		{"uid={{userFromUPN .UserName}},dc={{domainFromUPN .UserName}}", "j,doe@x@corp", `uid=j\,doe@x,dc=corp`},
+		{`{{netbios .UserName}}\{{userFromNetbios .UserName}}`, `CORP\jdoe`, `CORP\jdoe`},
+		{"cn={{with .UserName}}{{.}}{{end}},dc=example,dc=com", "a,b", `cn=a\,b,dc=example,dc=com`},
+		{"cn={{$u := .UserName}}{{$u}},dc=example,dc=com", "a+b", `cn=a\+b,dc=example,dc=com`},
+		{"{{.UserName}}@example.com", "j,doe", "j,doe@example.com"},
+	} {
+		got, err := RenderBindDN(c.pattern, c.name)
+		if err != nil || got != c.want {
+			t.Errorf("RenderBindDN(%s, %q) = %s, %v, want %s", c.pattern, c.name, got, err, c.want)
+		}
+	}
+
+	f, err := RenderUserFilter("(uid={{lower .UserName}})", "J*)(uid=*")
+	if want := `(uid=j\2a\29\28uid=\2a)`; err != nil || f != want {
+		t.Errorf("RenderUserFilter = %s, %v, want %s", f, err, want)
+	}
+}
+
+func TestCheckInjection(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		filter  bool
+	}{
+		{"uid={{.UserName}},ou=people,dc=example,dc=com", false},
+		{"cn={{lower .UserName}}+uid={{.UserName}},dc=example,dc=com", false},
+		{"(&(objectClass=person)(|(uid={{.UserName}})(mail={{.UserName}}*)))", true},
+	} {
+		for _, r := range CheckInjection(c.pattern, c.filter) {
+			if r.Error != nil || !r.Safe {
+				t.Errorf("%s: user %q renders as %s (%v)", c.pattern, r.UserName, r.Rendered, r.Error)
+			}
+		}
+	}
+
+	if rs := CheckInjection("{{.UserName}},dc=example,dc=com", false); len(rs) != 1 || rs[0].Error == nil {
+		t.Errorf("pattern without attribute type: got %+v", rs)
+	}
+}
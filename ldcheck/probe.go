@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// beyond that, Kerberos and time-based account checks start failing
+const maxClockSkew = 5 * time.Minute
+
+var rootDSEAttributes = []string{
+	"vendorName", "vendorVersion", "supportedLDAPVersion", "namingContexts", "defaultNamingContext",
+	"supportedControl", "supportedExtension", "supportedCapabilities", "supportedSASLMechanisms",
+	"subschemaSubentry", "currentTime", "dnsHostName", "objectClass",
+}
+
+// names of the controls, extensions and capabilities most often advertised
+var oidNames = map[string]string{
+	"1.2.840.113556.1.4.319":    "paged results",
+	"1.2.840.113556.1.4.417":    "show deleted",
+	"1.2.840.113556.1.4.473":    "server-side sort",
+	"1.2.840.113556.1.4.474":    "server-side sort response",
+	"1.2.840.113556.1.4.528":    "notification",
+	"1.2.840.113556.1.4.801":    "security descriptor flags",
+	"1.2.840.113556.1.4.841":    "DirSync",
+	"1.2.840.113556.1.4.1339":   "domain scope",
+	"1.2.840.113556.1.4.1340":   "search options",
+	"1.2.840.113556.1.4.1413":   "permissive modify",
+	"1.2.840.113556.1.4.2064":   "show recycled",
+	"1.2.840.113556.1.4.1781":   "fast concurrent bind",
+	"1.2.840.113556.1.4.800":    "Active Directory",
+	"1.2.840.113556.1.4.1670":   "Active Directory 2003",
+	"1.2.840.113556.1.4.1791":   "LDAP signing and sealing",
+	"1.2.840.113556.1.4.1935":   "Active Directory 2008",
+	"1.2.840.113556.1.4.2080":   "Active Directory 2008 R2",
+	"1.2.840.113556.1.4.2237":   "Active Directory 2012",
+	"1.3.6.1.4.1.42.2.27.8.5.1": "password policy",
+	"1.3.6.1.4.1.4203.1.9.1.1":  "content synchronization (syncrepl)",
+	"1.3.6.1.4.1.4203.1.10.1":   "subentries",
+	"1.3.6.1.4.1.4203.1.11.1":   "password modify",
+	"1.3.6.1.4.1.4203.1.11.3":   "Who Am I",
+	"1.3.6.1.4.1.1466.20037":    "StartTLS",
+	"1.3.6.1.1.8":               "cancel",
+	"1.3.6.1.1.12":              "assertion",
+	"1.3.6.1.1.13.1":            "pre-read",
+	"1.3.6.1.1.13.2":            "post-read",
+	"1.3.6.1.1.22":              "don't use copy",
+	"2.16.840.1.113730.3.4.2":   "ManageDsaIT",
+	"2.16.840.1.113730.3.4.4":   "password expired (Netscape)",
+	"2.16.840.1.113730.3.4.5":   "password expiring (Netscape)",
+	"2.16.840.1.113730.3.4.9":   "virtual list view",
+	"2.16.840.1.113730.3.4.18":  "proxied authorization",
+	"2.16.840.1.113730.3.4.16":  "authorization identity request",
+	"1.3.6.1.4.1.4203.666.5.12": "relax rules",
+	"1.3.6.1.4.1.42.2.27.9.5.8": "account usability",
+}
+
+const adCapability = "1.2.840.113556.1.4.800"
+
+// OID is an advertised control, extension or capability.
+type OID struct {
+	OID  string `json:"oid"`
+	Name string `json:"name,omitempty"`
+}
+
+// RootDSE describes the server, as read from the entry with the empty DN.
+type RootDSE struct {
+	Product              string   `json:"product"`
+	VendorName           string   `json:"vendor_name,omitempty"`
+	VendorVersion        string   `json:"vendor_version,omitempty"`
+	DNSHostName          string   `json:"dns_host_name,omitempty"`
+	LDAPVersions         []string `json:"supported_ldap_versions"`
+	NamingContexts       []string `json:"naming_contexts"`
+	DefaultNamingContext string   `json:"default_naming_context,omitempty"`
+	SubschemaSubentry    string   `json:"subschema_subentry,omitempty"`
+	Controls             []OID    `json:"supported_controls"`
+	Extensions           []OID    `json:"supported_extensions"`
+	Capabilities         []OID    `json:"supported_capabilities,omitempty"`
+	SASLMechanisms       []string `json:"supported_sasl_mechanisms"`
+
+	CurrentTime *time.Time `json:"current_time,omitempty"`
+	ClockSkew   *float64   `json:"clock_skew_seconds,omitempty"` // server minus local time
+	Features    []string   `json:"features"`                     // what ldcheck can use on this server
+	Warnings    []string   `json:"warnings,omitempty"`
+}
+
+// ReadRootDSE reads the RootDSE, as the service account when one is configured, anonymously otherwise.
+func ReadRootDSE(ctn *ldap.Conn, cfg LDAPConfig) (*RootDSE, error) {
+	if err := bindService(ctn, cfg); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	sr, err := ctn.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", rootDSEAttributes, nil))
+	if err != nil {
+		return nil, fmt.Errorf("reading the RootDSE: %w", err)
+	}
+	// the server time was read about half-way through the round trip
+	local := start.Add(time.Since(start) / 2)
+	if len(sr.Entries) != 1 {
+		return nil, fmt.Errorf("reading the RootDSE: %d entries returned", len(sr.Entries))
+	}
+	e := sr.Entries[0]
+
+	oids := func(attr string) []OID {
+		var l []OID
+		for _, v := range e.GetEqualFoldAttributeValues(attr) {
+			l = append(l, OID{OID: v, Name: oidNames[v]})
+		}
+		sort.Slice(l, func(i, j int) bool { return l[i].OID < l[j].OID })
+		return l
+	}
+	dse := &RootDSE{
+		VendorName:           e.GetEqualFoldAttributeValue("vendorName"),
+		VendorVersion:        e.GetEqualFoldAttributeValue("vendorVersion"),
+		DNSHostName:          e.GetEqualFoldAttributeValue("dnsHostName"),
+		LDAPVersions:         e.GetEqualFoldAttributeValues("supportedLDAPVersion"),
+		NamingContexts:       e.GetEqualFoldAttributeValues("namingContexts"),
+		DefaultNamingContext: e.GetEqualFoldAttributeValue("defaultNamingContext"),
+		SubschemaSubentry:    e.GetEqualFoldAttributeValue("subschemaSubentry"),
+		Controls:             oids("supportedControl"),
+		Extensions:           oids("supportedExtension"),
+		Capabilities:         oids("supportedCapabilities"),
+		SASLMechanisms:       e.GetEqualFoldAttributeValues("supportedSASLMechanisms"),
+	}
+
+	if ct := e.GetEqualFoldAttributeValue("currentTime"); ct != "" {
+		if t, err := ber.ParseGeneralizedTime([]byte(ct)); err == nil {
+			skew := t.Sub(local).Seconds()
+			dse.CurrentTime, dse.ClockSkew = &t, &skew
+			if d := time.Duration(skew * float64(time.Second)); d > maxClockSkew || d < -maxClockSkew {
+				dse.Warnings = append(dse.Warnings, fmt.Sprintf("server clock is %s off the local clock", d.Round(time.Second)))
+			}
+		}
+	}
+
+	dse.identify(e.GetEqualFoldAttributeValues("objectClass"))
+	dse.features()
+	return dse, nil
+}
+
+// bindService binds as the configured service account, the connection stays anonymous without one.
+func bindService(ctn *ldap.Conn, cfg LDAPConfig) error {
+	if cfg.BindDN == "" {
+		return nil
+	}
+	if err := ctn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+		return fmt.Errorf("service account %s denied: %w", cfg.BindDN, err)
+	}
+	return nil
+}
+
+// identify guesses the product, not every server sets vendorName.
+func (dse *RootDSE) identify(classes []string) {
+	switch {
+	case dse.VendorName != "":
+		dse.Product = strings.TrimSpace(dse.VendorName + " " + dse.VendorVersion)
+	case hasOID(dse.Capabilities, adCapability):
+		dse.Product = "Microsoft Active Directory (or Samba AD)"
+	case containsFold(classes, "OpenLDAProotDSE"):
+		dse.Product = "OpenLDAP"
+	default:
+		dse.Product = "unknown"
+	}
+}
+
+func (dse *RootDSE) features() {
+	feature := func(ok bool, name string) {
+		verdict := "not advertised"
+		if ok {
+			verdict = "supported"
+		}
+		dse.Features = append(dse.Features, name+": "+verdict)
+	}
+	ext := func(oid string) bool { return hasOID(dse.Extensions, oid) }
+
+	feature(hasOID(dse.Controls, ldap.ControlTypeBeheraPasswordPolicy), "password policy control (expiry warnings)")
+	feature(hasOID(dse.Controls, ldap.ControlTypePaging), "paged results")
+	feature(ext("1.3.6.1.4.1.1466.20037"), "StartTLS")
+	feature(ext("1.3.6.1.4.1.4203.1.11.3"), "Who Am I (SASL bind methods)")
+	feature(containsFold(dse.SASLMechanisms, "EXTERNAL"), "bind_method external")
+	feature(containsFold(dse.SASLMechanisms, "DIGEST-MD5"), "bind_method digest-md5")
+	feature(hasOID(dse.Capabilities, adCapability), "bind_method ntlm, in_chain groups and AD account status")
+	if len(dse.LDAPVersions) > 0 && !containsFold(dse.LDAPVersions, "3") {
+		dse.Warnings = append(dse.Warnings, "the server does not advertise LDAPv3")
+	}
+}
+
+func hasOID(l []OID, oid string) bool {
+	for _, o := range l {
+		if o.OID == oid {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(l []string, s string) bool {
+	for _, x := range l {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (dse *RootDSE) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dse)
+}
+
+func (dse *RootDSE) WriteText(w io.Writer) error {
+	fmt.Fprintln(w, "product:", dse.Product)
+	if dse.DNSHostName != "" {
+		fmt.Fprintln(w, "host:", dse.DNSHostName)
+	}
+	fmt.Fprintln(w, "LDAP versions:", strings.Join(dse.LDAPVersions, ", "))
+	fmt.Fprintln(w, "naming contexts:")
+	for _, nc := range dse.NamingContexts {
+		fmt.Fprintln(w, "  "+nc)
+	}
+	if dse.DefaultNamingContext != "" {
+		fmt.Fprintln(w, "default naming context:", dse.DefaultNamingContext)
+	}
+	if dse.SubschemaSubentry != "" {
+		fmt.Fprintln(w, "schema:", dse.SubschemaSubentry)
+	}
+	fmt.Fprintln(w, "SASL mechanisms:", strings.Join(dse.SASLMechanisms, ", "))
+
+	for _, s := range []struct {
+		title string
+		oids  []OID
+	}{{"controls", dse.Controls}, {"extensions", dse.Extensions}, {"capabilities", dse.Capabilities}} {
+		if len(s.oids) == 0 {
+			continue
+		}
+		fmt.Fprintln(w, s.title+":")
+		for _, o := range s.oids {
+			fmt.Fprintf(w, "  %-26s %s\n", o.OID, o.Name)
+		}
+	}
+
+	if dse.CurrentTime != nil {
+		fmt.Fprintf(w, "server time: %s, clock skew %.1fs\n", dse.CurrentTime.Format(time.RFC3339), *dse.ClockSkew)
+	}
+	fmt.Fprintln(w, "ldcheck features:")
+	for _, f := range dse.Features {
+		fmt.Fprintln(w, "  "+f)
+	}
+	for _, m := range dse.Warnings {
+		fmt.Fprintln(w, "WARNING:", m)
+	}
+	return nil
+}
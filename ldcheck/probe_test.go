@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestReadRootDSE(t *testing.T) {
+	dir := testDirectory()
+	dir.Entries[""] = map[string][]string{
+		"supportedLDAPVersion":    {"3", "2"},
+		"namingContexts":          {"dc=example,dc=com"},
+		"defaultNamingContext":    {"dc=example,dc=com"},
+		"supportedCapabilities":   {"1.2.840.113556.1.4.800"},
+		"supportedControl":        {"1.2.840.113556.1.4.319", "1.2.3.4"},
+		"supportedExtension":      {"1.3.6.1.4.1.1466.20037"},
+		"supportedSASLMechanisms": {"GSSAPI", "EXTERNAL"},
+		"currentTime":             {time.Now().UTC().Add(-time.Hour).Format("20060102150405.0Z")},
+	}
+	ctn, err := ldap.DialURL(startFakeDirectory(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctn.Close()
+
+	dse, err := ReadRootDSE(ctn, LDAPConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dse.Product, "Microsoft Active Directory") {
+		t.Errorf("product = %q", dse.Product)
+	}
+	if len(dse.Controls) != 2 || dse.Controls[1].Name != "paged results" || dse.Controls[0].Name != "" {
+		t.Errorf("controls = %v", dse.Controls)
+	}
+	if dse.ClockSkew == nil || *dse.ClockSkew > -3590 || len(dse.Warnings) != 1 {
+		t.Errorf("clock skew %v, warnings %q", dse.ClockSkew, dse.Warnings)
+	}
+	want := map[string]bool{"paged results: supported": true, "StartTLS: supported": true, "bind_method external: supported": true}
+	for _, f := range dse.Features {
+		delete(want, f)
+	}
+	if len(want) > 0 {
+		t.Errorf("missing features %v in %q", want, dse.Features)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Phases of a login check, as reported in timings and failures
+const (
+	PhaseConfig    = "config"
+	PhaseDial      = "dial"
+	PhaseResolve   = "resolve"
+	PhaseSearch    = "search"
+	PhaseBind      = "bind"
+	PhaseRead      = "read"
+	PhaseProfile   = "profile"
+	PhaseGroups    = "groups"
+	PhaseAuthorize = "authorize"
+)
+
+// Result holds everything learned while checking a login.
+// Both the text and JSON outputs are produced from it.
+type Result struct {
+	URL        string              `json:"url"`
+	Servers    []ServerAttempt     `json:"servers,omitempty"`
+	Network    string              `json:"network,omitempty"`
+	Address    string              `json:"address,omitempty"`
+	GC         bool                `json:"global_catalog,omitempty"`
+	StartTLS   string              `json:"starttls,omitempty"`
+	TLS        *TLSDetails         `json:"tls,omitempty"`
+	UserName   string              `json:"user_name"`
+	UserFilter string              `json:"user_filter,omitempty"`
+	UserDN     string              `json:"user_dn,omitempty"`
+	Patterns   []PatternAttempt    `json:"bind_patterns,omitempty"`
+	BindMethod string              `json:"bind_method,omitempty"`
+	Bind       *BindOutcome        `json:"bind,omitempty"`
+	AuthzID    string              `json:"authz_id,omitempty"`
+	Account    *AccountStatus      `json:"account,omitempty"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	Profile    []AttributeVerdict  `json:"profile,omitempty"`
+	Groups     []GroupResult       `json:"groups,omitempty"`
+	Role       *RoleDecision       `json:"role,omitempty"`
+	Timings    []Timing            `json:"timings"`
+
+	Status      string `json:"status"` // ok or failed
+	FailedPhase string `json:"failed_phase,omitempty"`
+	Error       string `json:"error,omitempty"`
+
+	Err error `json:"-"`
+}
+
+type TLSDetails struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name"`
+	PeerSubject string `json:"peer_subject,omitempty"`
+	PeerIssuer  string `json:"peer_issuer,omitempty"`
+	NotAfter    string `json:"not_after,omitempty"`
+}
+
+type BindOutcome struct {
+	Success    bool   `json:"success"`
+	ResultCode uint16 `json:"result_code"`
+	ResultName string `json:"result_name"`
+	Message    string `json:"message,omitempty"`
+	// Directory is the reason decoded from an Active Directory or Samba message.
+	Directory *ADBindError `json:"directory_reason,omitempty"`
+}
+
+type Timing struct {
+	Phase   string        `json:"phase"`
+	Millis  float64       `json:"ms"`
+	Elapsed time.Duration `json:"-"`
+}
+
+// Phase runs f, recording its duration and, on error, the failure.
+func (r *Result) Phase(name string, f func() error) error {
+	start := time.Now()
+	err := f()
+	el := time.Since(start)
+	r.Timings = append(r.Timings, Timing{Phase: name, Millis: ms(el), Elapsed: el})
+
+	if err != nil {
+		r.Status, r.FailedPhase, r.Error, r.Err = "failed", name, err.Error(), err
+	} else if r.Err == nil {
+		r.Status = "ok"
+	}
+	return err
+}
+
+// SetConn records how the connection was established.
+func (r *Result) SetConn(ctn *Conn) {
+	r.Network, r.Address, r.GC = ctn.Endpoint.Network, ctn.Endpoint.Addr, ctn.Endpoint.GC
+	switch {
+	case ctn.Upgraded:
+		r.StartTLS = "upgraded"
+	case ctn.StartTLSErr != nil:
+		r.StartTLS = "failed: " + ctn.StartTLSErr.Error()
+	}
+
+	if cs, ok := ctn.TLSConnectionState(); ok {
+		r.TLS = &TLSDetails{
+			Version:     versionName(cs.Version),
+			CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+			ServerName:  cs.ServerName,
+		}
+		if len(cs.PeerCertificates) > 0 {
+			leaf := cs.PeerCertificates[0]
+			r.TLS.PeerSubject = leaf.Subject.String()
+			r.TLS.PeerIssuer = leaf.Issuer.String()
+			r.TLS.NotAfter = leaf.NotAfter.Format(time.RFC3339)
+		}
+	}
+}
+
+func NewBindOutcome(err error) *BindOutcome {
+	if err == nil {
+		return &BindOutcome{Success: true, ResultName: ldap.LDAPResultCodeMap[ldap.LDAPResultSuccess]}
+	}
+
+	out := &BindOutcome{Message: err.Error()}
+	var lerr *ldap.Error
+	if errors.As(err, &lerr) {
+		out.ResultCode = lerr.ResultCode
+		out.ResultName = ldap.LDAPResultCodeMap[lerr.ResultCode]
+		if lerr.Err != nil {
+			out.Message = lerr.Err.Error()
+		}
+		out.Directory = DecodeADBindError(out.Message)
+	}
+	return out
+}
+
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Result) WriteText(w io.Writer) error {
+	if len(r.Servers) > 1 || len(r.Servers) == 1 && (r.Servers[0].Attempts > 1 || r.Servers[0].SRV != "") {
+		fmt.Fprintln(w, "servers tried:")
+		for _, s := range r.Servers {
+			switch {
+			case s.Attempts == 0:
+				fmt.Fprintf(w, "  %s: %s\n", s.URL, s.Error)
+			case s.Error != "":
+				fmt.Fprintf(w, "  %s: skipped after %d attempts, %.0fms: %s\n", s.URL, s.Attempts, s.Millis, s.Error)
+			default:
+				fmt.Fprintf(w, "  %s: connected in %.1fms\n", s.URL, s.Millis)
+			}
+			if s.SRV != "" {
+				fmt.Fprintf(w, "    discovered from %s\n", s.SRV)
+			}
+		}
+	}
+	fmt.Fprintln(w, "server:", r.URL)
+	if r.Address != "" && r.GC {
+		fmt.Fprintf(w, "connected over %s to %s, a global catalog\n", r.Network, r.Address)
+	} else if r.Address != "" {
+		fmt.Fprintf(w, "connected over %s to %s\n", r.Network, r.Address)
+	}
+	if r.StartTLS != "" {
+		fmt.Fprintln(w, "StartTLS:", r.StartTLS)
+	}
+	if r.TLS != nil {
+		fmt.Fprintf(w, "TLS: %s, %s, certificate %s issued by %s\n", r.TLS.Version, r.TLS.CipherSuite, r.TLS.PeerSubject, r.TLS.PeerIssuer)
+	} else if r.Network == "tcp" {
+		fmt.Fprintln(w, "TLS: none, the password is sent in cleartext")
+	}
+	if r.UserFilter != "" {
+		fmt.Fprintln(w, "searching user with filter:", r.UserFilter)
+	}
+	if len(r.Patterns) > 0 {
+		fmt.Fprintln(w, "bind patterns tried:")
+		for _, p := range r.Patterns {
+			fmt.Fprintf(w, "  %s: %s\n", p.DN, p.Outcome)
+		}
+	}
+	if r.UserDN != "" {
+		fmt.Fprintln(w, "checking user DN:", r.UserDN)
+	}
+	if r.Bind != nil {
+		switch {
+		case r.Bind.Success && r.AuthzID != "":
+			fmt.Fprintf(w, "bind: success with %s, authenticated as %s\n", r.BindMethod, r.AuthzID)
+		case r.Bind.Success:
+			fmt.Fprintf(w, "bind: success with %s\n", r.BindMethod)
+		case r.Bind.ResultName == "":
+			fmt.Fprintln(w, "bind: failed,", r.Bind.Message)
+		default:
+			fmt.Fprintf(w, "bind: %s (%d) %s\n", r.Bind.ResultName, r.Bind.ResultCode, r.Bind.Message)
+		}
+		if d := r.Bind.Directory; d != nil {
+			fmt.Fprintf(w, "  data %s, %s: %s\n", d.Code, d.Reason, d.Explanation)
+		}
+	}
+
+	if len(r.Attributes) > 0 {
+		names := make([]string, 0, len(r.Attributes))
+		for n := range r.Attributes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "attributes:")
+		for _, n := range names {
+			fmt.Fprintf(w, "  %s: %s\n", n, strings.Join(r.Attributes[n], "; "))
+		}
+	}
+
+	if a := r.Account; a != nil {
+		fmt.Fprintln(w, "account:")
+		if pp := a.PasswordPolicy; pp != nil {
+			fmt.Fprint(w, "  password policy control:")
+			if pp.ExpiresIn != nil {
+				fmt.Fprintf(w, " expires in %ds", *pp.ExpiresIn)
+			}
+			if pp.GraceLogins != nil {
+				fmt.Fprintf(w, " %d grace logins", *pp.GraceLogins)
+			}
+			if pp.Error != "" {
+				fmt.Fprintf(w, " error %q", pp.Error)
+			}
+			fmt.Fprintln(w)
+		}
+		if ad := a.AD; ad != nil {
+			fmt.Fprintln(w, "  userAccountControl:", strings.Join(ad.Flags, ", "))
+			date := func(label string, t *time.Time) {
+				if t != nil {
+					fmt.Fprintf(w, "  %s: %s\n", label, t.Format(time.RFC1123))
+				}
+			}
+			date("password last set", ad.PasswordLastSet)
+			date("password expires", ad.PasswordExpires)
+			date("locked since", ad.LockedSince)
+			date("account expires", ad.AccountExpires)
+		}
+		for _, m := range a.Warnings {
+			fmt.Fprintln(w, "  WARNING:", m)
+		}
+	}
+
+	if len(r.Profile) > 0 {
+		fmt.Fprintln(w, "profile:")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, v := range r.Profile {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", v.Field, v.Attribute, v.Verdict, strings.Join(v.Values, "; "))
+		}
+		tw.Flush()
+	}
+
+	if len(r.Groups) > 0 {
+		fmt.Fprintln(w, "groups:")
+	}
+	for _, g := range r.Groups {
+		if g.Error != "" {
+			fmt.Fprintf(w, "  %s: error, %s\n", g.Strategy, g.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %d groups\n", g.Strategy, len(g.Groups))
+		for _, m := range g.Groups {
+			if len(m.Path) == 0 {
+				fmt.Fprintf(w, "    %s\n", m.DN)
+			} else {
+				fmt.Fprintf(w, "    %s (nested, via %s)\n", m.DN, strings.Join(m.Path, " > "))
+			}
+		}
+	}
+
+	if r.Role != nil {
+		switch {
+		case !r.Role.Allowed:
+			fmt.Fprintln(w, "role: login denied,", r.Role.Reason)
+		case r.Role.Rule == nil:
+			fmt.Fprintf(w, "role: %s (default_role, no rule matched)\n", r.Role.Role)
+		default:
+			fmt.Fprintf(w, "role: %s (rule: %s)\n", r.Role.Role, r.Role.Rule)
+		}
+		for _, c := range r.Role.Conflicts {
+			fmt.Fprintf(w, "  conflicting rule also matched: %s\n", c)
+		}
+		for _, m := range r.Role.Incomplete {
+			fmt.Fprintln(w, "  INCOMPLETE, groups unknown from", m)
+		}
+	}
+
+	var tm []string
+	for _, t := range r.Timings {
+		tm = append(tm, fmt.Sprintf("%s %s", t.Phase, t.Elapsed.Round(time.Microsecond)))
+	}
+	fmt.Fprintln(w, "timings:", strings.Join(tm, ", "))
+
+	if r.Err != nil {
+		fmt.Fprintf(w, "FAILED during %s: %s\n", r.FailedPhase, r.Error)
+	} else {
+		fmt.Fprintln(w, "status: ok")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Security Hub roles, from most to least privileged
+var roleRanks = map[string]int{"admin": 3, "analyst": 2, "viewer": 1}
+
+type RoleConfig struct {
+	// RequiredFilter must match the user entry for the login to be allowed at all.
+	RequiredFilter string     `toml:"required_filter"`
+	DefaultRole    string     `toml:"default_role"` // given when no rule matches; empty denies the login
+	Rules          []RoleRule `toml:"rules"`
+}
+
+// RoleRule grants Role to members of Group, or to users whose entry matches Filter.
+type RoleRule struct {
+	Role   string `toml:"role" json:"role"`
+	Group  string `toml:"group" json:"group,omitempty"`
+	Filter string `toml:"filter" json:"filter,omitempty"`
+}
+
+func (r RoleRule) String() string {
+	if r.Group != "" {
+		return fmt.Sprintf("%s if member of %s", r.Role, r.Group)
+	}
+	return fmt.Sprintf("%s if entry matches %s", r.Role, r.Filter)
+}
+
+func (c RoleConfig) configured() bool {
+	return c.RequiredFilter != "" || len(c.Rules) > 0
+}
+
+// RoleDecision is the role the user would receive.
+type RoleDecision struct {
+	Allowed   bool       `json:"allowed"`
+	Role      string     `json:"role,omitempty"`
+	Rule      *RoleRule  `json:"rule,omitempty"` // nil when the default role applies
+	Conflicts []RoleRule `json:"conflicts,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	// Incomplete lists the group lookups that failed while group rules depend on them.
+	Incomplete []string `json:"incomplete,omitempty"`
+}
+
+// DecideRole evaluates the role rules against the user groups and entry.
+func DecideRole(ctn *ldap.Conn, cfg RoleConfig, userdn string, groups []GroupResult) (*RoleDecision, error) {
+	matches := func(filter string) (bool, error) {
+		sr, err := ctn.Search(ldap.NewSearchRequest(userdn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, filter, []string{"1.1"}, nil))
+		if err != nil {
+			return false, fmt.Errorf("evaluating %s: %w", filter, err)
+		}
+		return len(sr.Entries) > 0, nil
+	}
+
+	d := new(RoleDecision)
+	for _, r := range cfg.Rules {
+		if r.Group == "" {
+			continue
+		}
+		for _, g := range groups {
+			if g.Error != "" {
+				d.Incomplete = append(d.Incomplete, g.Strategy+": "+g.Error)
+			}
+		}
+		break
+	}
+
+	if cfg.RequiredFilter != "" {
+		ok, err := matches(cfg.RequiredFilter)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			d.Reason = "user entry does not match required_filter " + cfg.RequiredFilter
+			return d, nil
+		}
+	}
+
+	var matched []RoleRule
+	for _, r := range cfg.Rules {
+		ok := false
+		switch {
+		case r.Group != "":
+			ok = memberOf(groups, r.Group)
+		case r.Filter != "":
+			var err error
+			if ok, err = matches(r.Filter); err != nil {
+				return nil, err
+			}
+		}
+		if ok {
+			matched = append(matched, r)
+		}
+	}
+
+	if len(matched) == 0 {
+		if cfg.DefaultRole == "" {
+			d.Reason = "no role rule matches and there is no default_role"
+			return d, nil
+		}
+		d.Allowed, d.Role = true, cfg.DefaultRole
+		return d, nil
+	}
+
+	best := 0
+	for i, r := range matched {
+		if roleRanks[r.Role] > roleRanks[matched[best].Role] {
+			best = i
+		}
+	}
+	d.Allowed, d.Role, d.Rule = true, matched[best].Role, &matched[best]
+	for i, r := range matched {
+		if i != best && r.Role != d.Role {
+			d.Conflicts = append(d.Conflicts, r)
+		}
+	}
+	return d, nil
+}
+
+func memberOf(groups []GroupResult, group string) bool {
+	for _, g := range groups {
+		for _, m := range g.Groups {
+			if sameDN(m.DN, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameDN compares DNs ignoring case and the spacing between RDNs.
+func sameDN(a, b string) bool {
+	da, erra := ldap.ParseDN(a)
+	db, errb := ldap.ParseDN(b)
+	if erra != nil || errb != nil || len(da.RDNs) != len(db.RDNs) {
+		return strings.EqualFold(a, b)
+	}
+	for i := range da.RDNs {
+		ra, rb := da.RDNs[i].Attributes, db.RDNs[i].Attributes
+		if len(ra) != len(rb) {
+			return false
+		}
+		for j := range ra {
+			if !strings.EqualFold(ra[j].Type, rb[j].Type) || !strings.EqualFold(ra[j].Value, rb[j].Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
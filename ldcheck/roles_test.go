@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestDecideRole(t *testing.T) {
+	const admins = "cn=admins,ou=groups,dc=example,dc=com"
+	dir := testDirectory()
+	dir.Entries["uid=jdoe,ou=people,dc=example,dc=com"]["memberOf"] = []string{admins}
+
+	cfg := LDAPConfig{
+		ServerURL:       startFakeDirectory(t, dir),
+		BindUserPattern: "uid={{.UserName}},ou=people,dc=example,dc=com",
+		Roles: RoleConfig{
+			RequiredFilter: "(mail=*)",
+			Rules: []RoleRule{
+				{Role: "viewer", Filter: "(mail=jdoe@example.com)"},
+				{Role: "admin", Group: "CN=Admins, OU=groups, DC=example, DC=com"},
+				{Role: "analyst", Group: "cn=soc,ou=groups,dc=example,dc=com"},
+			},
+		},
+	}
+	res := TestLoginWithLDAP(cfg, "jdoe", "secret")
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if res.Role.Role != "admin" || res.Role.Rule.Group == "" || len(res.Role.Conflicts) != 1 || res.Role.Conflicts[0].Role != "viewer" {
+		t.Errorf("got role %+v", res.Role)
+	}
+
+	cfg.Roles.RequiredFilter = "(employeeType=staff)"
+	res = TestLoginWithLDAP(cfg, "jdoe", "secret")
+	if res.Role == nil || res.Role.Allowed || ExitCode(res) != ExitSearch {
+		t.Errorf("required filter not enforced: %+v, %v", res.Role, res.Err)
+	}
+
+	// one of the group lookups fails: the admin group could be missed
+	cfg.Roles.RequiredFilter = ""
+	cfg.Groups = GroupConfig{Strategies: []string{StrategyMemberOf, "memberof-typo"}}
+	res = TestLoginWithLDAP(cfg, "jdoe", "secret")
+	if res.Role == nil || len(res.Role.Incomplete) != 1 || res.FailedPhase != PhaseAuthorize || ExitCode(res) != ExitSearch {
+		t.Errorf("failed group lookup not reported: %+v, %v", res.Role, res.Err)
+	}
+
+	cfg.Roles.Rules = cfg.Roles.Rules[:1]
+	res = TestLoginWithLDAP(cfg, "jdoe", "secret")
+	if res.Err != nil || len(res.Role.Incomplete) != 0 {
+		t.Errorf("filter rules do not depend on groups: %+v, %v", res.Role, res.Err)
+	}
+}
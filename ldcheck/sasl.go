@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Bind methods
+const (
+	BindSimple    = "simple"
+	BindExternal  = "external"   // SASL EXTERNAL, with ldapi peer credentials or a TLS client certificate
+	BindDigestMD5 = "digest-md5" // SASL DIGEST-MD5, authenticates the user name rather than a DN
+	BindNTLM      = "ntlm"       // Microsoft NTLM, with DOMAIN\user names
+)
+
+func validBindMethod(m string) error {
+	switch strings.ToLower(m) {
+	case "", BindSimple, BindExternal, BindDigestMD5, BindNTLM:
+		return nil
+	}
+	return fmt.Errorf("unknown bind_method %q: want %s, %s, %s or %s", m, BindSimple, BindExternal, BindDigestMD5, BindNTLM)
+}
+
+func isSASL(m string) bool {
+	m = strings.ToLower(m)
+	return m != "" && m != BindSimple
+}
+
+// BindUser authenticates as the user with the configured bind method.
+// Simple binds use the user DN, the other methods use the user name.
+// Simple binds request the password policy control, returned when the server sends it back.
+func BindUser(ctn *Conn, cfg LDAPConfig, name, userdn, pass string) (*ldap.ControlBeheraPasswordPolicy, error) {
+	switch strings.ToLower(cfg.BindMethod) {
+	case BindExternal:
+		_, tlsok := ctn.TLSConnectionState()
+		if ctn.Endpoint.Network != "unix" && !(tlsok && cfg.TLS.ClientCert != "") {
+			return nil, ldap.NewError(ldap.LDAPResultInappropriateAuthentication,
+				errors.New("SASL EXTERNAL needs an ldapi:// URL or a TLS connection with a client certificate"))
+		}
+		return nil, ctn.ExternalBind()
+	case BindDigestMD5:
+		return nil, ctn.MD5Bind(ctn.Endpoint.Host, name, pass)
+	case BindNTLM:
+		domain, user := SplitDomainUser(name, cfg.NTLMDomain)
+		if cfg.NTLMHash {
+			return nil, ctn.NTLMBindWithHash(domain, user, pass)
+		}
+		return nil, ctn.NTLMBind(domain, user, pass)
+	}
+
+	req := ldap.NewSimpleBindRequest(userdn, pass, []ldap.Control{ldap.NewControlBeheraPasswordPolicy()})
+	br, err := ctn.SimpleBind(req)
+	if br == nil {
+		return nil, err
+	}
+	pp, _ := ldap.FindControl(br.Controls, ldap.ControlTypeBeheraPasswordPolicy).(*ldap.ControlBeheraPasswordPolicy)
+	return pp, err
+}
+
+// SplitDomainUser splits DOMAIN\user names, using the default domain for plain names.
+// User principal names (user@domain) are passed whole with an empty domain.
+func SplitDomainUser(name, defaultDomain string) (domain, user string) {
+	if d, u, ok := strings.Cut(name, `\`); ok {
+		return d, u
+	}
+	if strings.Contains(name, "@") {
+		return "", name
+	}
+	return defaultDomain, name
+}
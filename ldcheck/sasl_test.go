@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestBindMethods(t *testing.T) {
+	const (
+		jdoe   = "uid=jdoe,ou=people,dc=example,dc=com"
+		nthash = "878d8014606cda29677a44efa1353fc7" // of secret
+	)
+	dir := testDirectory()
+	dir.Passwords["jdoe"] = "secret"
+	dir.NTHashes = map[string]string{`CORP\jdoe`: nthash}
+	dir.AuthzIDs = map[string]string{"jdoe": "dn:" + jdoe, `CORP\jdoe`: "dn:" + jdoe}
+	tcp := startFakeDirectory(t, dir)
+
+	peer := testDirectory()
+	peer.External = "dn:" + jdoe
+	ldapi := startFakeDirectoryUnix(t, peer)
+
+	anonymous := testDirectory()
+	anonymous.External = "dn:gidNumber=1000+uidNumber=1000,cn=peercred,cn=external,cn=auth"
+	unmapped := startFakeDirectoryUnix(t, anonymous)
+
+	for _, c := range []struct {
+		name       string
+		cfg        LDAPConfig
+		user, pass string
+		exit       int
+		phase      string // failing phase
+		authz      string
+	}{
+		{"external over tcp", LDAPConfig{ServerURL: tcp, BindMethod: "EXTERNAL"}, "jdoe", "", ExitConfig, PhaseBind, ""},
+		{"external over ldapi", LDAPConfig{ServerURL: ldapi, BindMethod: BindExternal}, "jdoe", "", ExitOK, "", "dn:" + jdoe},
+		{"external unmapped", LDAPConfig{ServerURL: unmapped, BindMethod: BindExternal}, "jdoe", "", ExitSearch, PhaseRead, anonymous.External},
+		{"digest-md5", LDAPConfig{ServerURL: tcp, BindMethod: BindDigestMD5}, "jdoe", "secret", ExitOK, "", "dn:" + jdoe},
+		{"digest-md5 wrong password", LDAPConfig{ServerURL: tcp, BindMethod: BindDigestMD5}, "jdoe", "wrong", ExitInvalidCredentials, PhaseBind, ""},
+		{"digest-md5 empty password", LDAPConfig{ServerURL: tcp, BindMethod: BindDigestMD5}, "jdoe", "", ExitInvalidCredentials, PhaseBind, ""},
+		{"ntlm", LDAPConfig{ServerURL: tcp, BindMethod: BindNTLM}, `corp\jdoe`, "secret", ExitOK, "", "dn:" + jdoe},
+		{"ntlm default domain", LDAPConfig{ServerURL: tcp, BindMethod: BindNTLM, NTLMDomain: "CORP"}, "jdoe", "secret", ExitOK, "", "dn:" + jdoe},
+		{"ntlm hash", LDAPConfig{ServerURL: tcp, BindMethod: BindNTLM, NTLMDomain: "CORP", NTLMHash: true}, "jdoe", nthash, ExitOK, "", "dn:" + jdoe},
+		{"ntlm wrong password", LDAPConfig{ServerURL: tcp, BindMethod: BindNTLM}, `CORP\jdoe`, "wrong", ExitInvalidCredentials, PhaseBind, ""},
+		{"ntlm wrong domain", LDAPConfig{ServerURL: tcp, BindMethod: BindNTLM}, `OTHER\jdoe`, "secret", ExitInvalidCredentials, PhaseBind, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			res := TestLoginWithLDAP(c.cfg, c.user, c.pass)
+			if got := ExitCode(res); got != c.exit || res.FailedPhase != c.phase {
+				t.Fatalf("exit code %d in phase %q, want %d in %q: %v", got, res.FailedPhase, c.exit, c.phase, res.Err)
+			}
+			if res.AuthzID != c.authz {
+				t.Errorf("authorization identity %q, want %q", res.AuthzID, c.authz)
+			}
+			if dn, ok := strings.CutPrefix(c.authz, "dn:"); ok && res.UserDN != dn {
+				t.Errorf("user DN %q, want the one of Who Am I %q", res.UserDN, dn)
+			}
+			if c.exit == ExitInvalidCredentials && c.pass != "" && res.Bind.ResultCode != ldap.LDAPResultInvalidCredentials {
+				t.Errorf("rejected by the client, not the server: %s", res.Bind.Message)
+			}
+			if c.exit == ExitOK && res.Attributes["mail"] == nil {
+				t.Errorf("user entry not read: %v", res.Attributes)
+			}
+		})
+	}
+
+	// the user name is sent with DIGEST-MD5 and NTLM, but a pattern still locates the entry
+	cfg := LDAPConfig{ServerURL: tcp, BindMethod: BindDigestMD5, BindUserPattern: "uid={{.UserName}},ou=people,dc=example,dc=com"}
+	dir.AuthzIDs["jdoe"] = "u:jdoe"
+	if res := TestLoginWithLDAP(cfg, "jdoe", "secret"); res.Err != nil || res.UserDN != jdoe || res.AuthzID != "u:jdoe" {
+		t.Errorf("digest-md5 with pattern: user DN %q, authz %q: %v", res.UserDN, res.AuthzID, res.Err)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// AttributeType is an attribute definition of the subschema (RFC 4512, section 4.1.2).
+type AttributeType struct {
+	OID         string
+	Names       []string
+	Sup         string
+	Syntax      string
+	SingleValue bool
+}
+
+// Name is the name the server returns the attribute under.
+func (a *AttributeType) Name() string {
+	if len(a.Names) > 0 {
+		return a.Names[0]
+	}
+	return a.OID
+}
+
+// ObjectClass is an object class definition of the subschema.
+type ObjectClass struct {
+	OID   string
+	Names []string
+}
+
+// Schema indexes the definitions by lowercase name and OID.
+type Schema struct {
+	Attributes map[string]*AttributeType
+	Classes    map[string]*ObjectClass
+}
+
+var syntaxNames = map[string]string{
+	"1.3.6.1.4.1.1466.115.121.1.5":  "Binary",
+	"1.3.6.1.4.1.1466.115.121.1.7":  "Boolean",
+	"1.3.6.1.4.1.1466.115.121.1.11": "Country String",
+	"1.3.6.1.4.1.1466.115.121.1.12": "DN",
+	"1.3.6.1.4.1.1466.115.121.1.15": "Directory String",
+	"1.3.6.1.4.1.1466.115.121.1.24": "Generalized Time",
+	"1.3.6.1.4.1.1466.115.121.1.26": "IA5 String",
+	"1.3.6.1.4.1.1466.115.121.1.27": "Integer",
+	"1.3.6.1.4.1.1466.115.121.1.28": "JPEG",
+	"1.3.6.1.4.1.1466.115.121.1.34": "Name and Optional UID",
+	"1.3.6.1.4.1.1466.115.121.1.36": "Numeric String",
+	"1.3.6.1.4.1.1466.115.121.1.38": "OID",
+	"1.3.6.1.4.1.1466.115.121.1.40": "Octet String",
+	"1.3.6.1.4.1.1466.115.121.1.44": "Printable String",
+	"1.3.6.1.4.1.1466.115.121.1.50": "Telephone Number",
+	"1.3.6.1.4.1.1466.115.121.1.53": "UTC Time",
+	"1.2.840.113556.1.4.906":        "Large Integer",
+	"1.2.840.113556.1.4.907":        "Security Descriptor",
+}
+
+// syntaxes the profile fields can be displayed from
+var textSyntaxes = map[string]bool{
+	"1.3.6.1.4.1.1466.115.121.1.11": true,
+	"1.3.6.1.4.1.1466.115.121.1.15": true,
+	"1.3.6.1.4.1.1466.115.121.1.26": true,
+	"1.3.6.1.4.1.1466.115.121.1.36": true,
+	"1.3.6.1.4.1.1466.115.121.1.44": true,
+	"1.3.6.1.4.1.1466.115.121.1.50": true,
+}
+
+const dnSyntax = "1.3.6.1.4.1.1466.115.121.1.12"
+
+func syntaxName(oid string) string {
+	if n, ok := syntaxNames[oid]; ok {
+		return n
+	}
+	if oid == "" {
+		return "unknown syntax"
+	}
+	return "syntax " + oid
+}
+
+// ReadSchema reads the subschema subentry advertised in the RootDSE.
+func ReadSchema(ctn *ldap.Conn, cfg LDAPConfig) (*Schema, error) {
+	if err := bindService(ctn, cfg); err != nil {
+		return nil, err
+	}
+
+	sub := "cn=Subschema"
+	sr, err := ctn.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"subschemaSubentry"}, nil))
+	if err == nil && len(sr.Entries) == 1 && sr.Entries[0].GetEqualFoldAttributeValue("subschemaSubentry") != "" {
+		sub = sr.Entries[0].GetEqualFoldAttributeValue("subschemaSubentry")
+	}
+
+	sr, err = ctn.Search(ldap.NewSearchRequest(sub, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=subschema)", []string{"attributeTypes", "objectClasses"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("reading the schema from %s: %w", sub, err)
+	}
+	if len(sr.Entries) != 1 {
+		return nil, fmt.Errorf("reading the schema from %s: %d entries returned", sub, len(sr.Entries))
+	}
+	return ParseSchema(sr.Entries[0].GetEqualFoldAttributeValues("attributeTypes"),
+		sr.Entries[0].GetEqualFoldAttributeValues("objectClasses"))
+}
+
+// ParseSchema parses attributeTypes and objectClasses descriptions.
+func ParseSchema(attributeTypes, objectClasses []string) (*Schema, error) {
+	s := &Schema{Attributes: make(map[string]*AttributeType), Classes: make(map[string]*ObjectClass)}
+	for _, d := range attributeTypes {
+		oid, fields, err := parseDescription(d)
+		if err != nil {
+			return nil, fmt.Errorf("attribute type %s: %w", d, err)
+		}
+		a := &AttributeType{OID: oid, Names: fields["NAME"], SingleValue: fields["SINGLE-VALUE"] != nil}
+		if len(fields["SUP"]) > 0 {
+			a.Sup = fields["SUP"][0]
+		}
+		if len(fields["SYNTAX"]) > 0 {
+			a.Syntax, _, _ = strings.Cut(fields["SYNTAX"][0], "{") // drop the length bound
+		}
+		for _, k := range append([]string{oid}, a.Names...) {
+			s.Attributes[strings.ToLower(k)] = a
+		}
+	}
+	for _, d := range objectClasses {
+		oid, fields, err := parseDescription(d)
+		if err != nil {
+			return nil, fmt.Errorf("object class %s: %w", d, err)
+		}
+		c := &ObjectClass{OID: oid, Names: fields["NAME"]}
+		for _, k := range append([]string{oid}, c.Names...) {
+			s.Classes[strings.ToLower(k)] = c
+		}
+	}
+
+	// subtypes inherit the syntax of their supertype
+	for _, a := range s.Attributes {
+		for t, n := a, 0; a.Syntax == "" && t.Sup != "" && n < 16; n++ {
+			if t = s.Attributes[strings.ToLower(t.Sup)]; t == nil {
+				break
+			}
+			a.Syntax = t.Syntax
+		}
+	}
+	return s, nil
+}
+
+// keywords without value
+var schemaFlags = map[string]bool{
+	"SINGLE-VALUE": true, "COLLECTIVE": true, "NO-USER-MODIFICATION": true, "OBSOLETE": true,
+	"ABSTRACT": true, "STRUCTURAL": true, "AUXILIARY": true,
+}
+
+// parseDescription splits "( oid KEYWORD value KEYWORD ( list ) ... )" into the OID and the values of each keyword.
+func parseDescription(d string) (string, map[string][]string, error) {
+	toks, err := schemaTokens(d)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(toks) < 3 || toks[0] != "(" || toks[len(toks)-1] != ")" {
+		return "", nil, errors.New("not a parenthesized description")
+	}
+	toks = toks[1 : len(toks)-1]
+
+	oid, fields := toks[0], make(map[string][]string)
+	for i := 1; i < len(toks); i++ {
+		kw := toks[i]
+		if schemaFlags[kw] {
+			fields[kw] = []string{}
+			continue
+		}
+		if i+1 >= len(toks) {
+			return "", nil, fmt.Errorf("missing value for %s", kw)
+		}
+		i++
+		if toks[i] != "(" {
+			fields[kw] = []string{toks[i]}
+			continue
+		}
+		var vs []string
+		for i++; i < len(toks) && toks[i] != ")"; i++ {
+			if toks[i] != "$" {
+				vs = append(vs, toks[i])
+			}
+		}
+		fields[kw] = vs
+	}
+	return oid, fields, nil
+}
+
+func schemaTokens(d string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(d); {
+		switch c := d[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == '$':
+			toks = append(toks, string(c))
+			i++
+		case c == '\'':
+			end := strings.IndexByte(d[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated quoted string")
+			}
+			toks = append(toks, d[i+1:i+1+end])
+			i += end + 2
+		default:
+			j := i
+			for j < len(d) && !strings.ContainsRune(" \t\n()$'", rune(d[j])) {
+				j++
+			}
+			toks = append(toks, d[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// filterTerms collects the attributes and object classes a filter uses.
+func filterTerms(p *ber.Packet, attrs, classes *[]string) {
+	switch p.Tag {
+	case ldap.FilterAnd, ldap.FilterOr, ldap.FilterNot:
+		for _, c := range p.Children {
+			filterTerms(c, attrs, classes)
+		}
+	case ldap.FilterPresent:
+		*attrs = append(*attrs, p.Data.String())
+	case ldap.FilterEqualityMatch, ldap.FilterSubstrings, ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual, ldap.FilterApproxMatch:
+		attr := p.Children[0].Data.String()
+		*attrs = append(*attrs, attr)
+		if p.Tag == ldap.FilterEqualityMatch && strings.EqualFold(attr, "objectClass") {
+			*classes = append(*classes, p.Children[1].Data.String())
+		}
+	case ldap.FilterExtensibleMatch:
+		for _, c := range p.Children {
+			if c.Tag == 2 { // type
+				*attrs = append(*attrs, c.Data.String())
+			}
+		}
+	}
+}
+
+// CheckSchema validates the attributes and object classes the configuration uses against the server schema.
+func CheckSchema(s *Schema, cfg LDAPConfig) []Finding {
+	var fs []Finding
+	errorf := func(format string, args ...any) { fs = append(fs, Finding{"error", fmt.Sprintf(format, args...)}) }
+	warnf := func(format string, args ...any) { fs = append(fs, Finding{"warning", fmt.Sprintf(format, args...)}) }
+	infof := func(format string, args ...any) { fs = append(fs, Finding{"info", fmt.Sprintf(format, args...)}) }
+
+	var attrNames, classNames []string
+	for k, a := range s.Attributes {
+		if len(a.Names) > 0 && k == strings.ToLower(a.Names[0]) {
+			attrNames = append(attrNames, a.Names...)
+		}
+	}
+	for k, c := range s.Classes {
+		if len(c.Names) > 0 && k == strings.ToLower(c.Names[0]) {
+			classNames = append(classNames, c.Names...)
+		}
+	}
+	sort.Strings(attrNames)
+	sort.Strings(classNames)
+
+	// lookup reports missing and miscased attributes
+	lookup := func(where, name string) *AttributeType {
+		a := s.Attributes[strings.ToLower(name)]
+		switch {
+		case a == nil && Closest(name, attrNames) != "":
+			errorf("%s: attribute %s is not in the schema, did you mean %s?", where, name, Closest(name, attrNames))
+		case a == nil:
+			errorf("%s: attribute %s is not in the schema", where, name)
+		case !containsString(a.Names, name) && a.OID != name:
+			warnf("%s: attribute %s is spelled %s in the schema, case-sensitive code will not find it", where, name, a.Name())
+		}
+		return a
+	}
+
+	for _, f := range cfg.Attributes.fields() {
+		for _, name := range f.Attrs {
+			name = strings.TrimSpace(name)
+			a := lookup(f.Field, name)
+			if a == nil {
+				continue
+			}
+			valued := "multi-valued"
+			if a.SingleValue {
+				valued = "single-valued"
+			}
+			infof("%s: %s, %s, %s", f.Field, a.Name(), syntaxName(a.Syntax), valued)
+			switch {
+			case f.Field == "groups" && a.Syntax != dnSyntax:
+				warnf("groups: %s has %s, not DN: values will not match group DNs", a.Name(), syntaxName(a.Syntax))
+			case f.Field == "display_name" || f.Field == "email":
+				if a.Syntax != "" && !textSyntaxes[a.Syntax] {
+					warnf("%s: %s has %s, not a text syntax", f.Field, a.Name(), syntaxName(a.Syntax))
+				}
+				if !a.SingleValue {
+					infof("%s: %s may hold several values, users with more than one fail the profile check", f.Field, a.Name())
+				}
+			}
+		}
+	}
+
+	for _, st := range cfg.Groups.Strategies {
+		var attr string
+		switch {
+		case strings.EqualFold(st, StrategyMemberOf):
+			attr = cfg.Groups.memberOfAttribute(cfg.Attributes)
+		case strings.EqualFold(st, StrategyMember), strings.EqualFold(st, StrategyInChain):
+			attr = "member"
+		case strings.EqualFold(st, StrategyUniqueMember):
+			attr = "uniqueMember"
+		case strings.EqualFold(st, StrategyMemberUID):
+			attr = "memberUid"
+		default:
+			continue
+		}
+		if lookup("groups strategy "+st, attr) == nil && strings.EqualFold(attr, "memberOf") {
+			infof("groups strategy %s: OpenLDAP only has memberOf with the memberof overlay loaded", st)
+		}
+	}
+
+	filters := []struct{ where, filter string }{
+		{"groups filter", cfg.Groups.Filter},
+		{"roles required_filter", cfg.Roles.RequiredFilter},
+	}
+	if cfg.UserFilter != "" {
+		f, _ := RenderUserFilter(cfg.UserFilter, "jdoe")
+		filters = append(filters, struct{ where, filter string }{"user_filter", f})
+	}
+	for i, r := range cfg.Roles.Rules {
+		filters = append(filters, struct{ where, filter string }{fmt.Sprintf("role rule #%d", i+1), r.Filter})
+	}
+	for _, f := range filters {
+		if f.filter == "" {
+			continue
+		}
+		p, err := ldap.CompileFilter(f.filter)
+		if err != nil {
+			continue // reported by -lint
+		}
+		var attrs, classes []string
+		filterTerms(p, &attrs, &classes)
+		for _, a := range attrs {
+			lookup(f.where, a)
+		}
+		for _, c := range classes {
+			switch {
+			case s.Classes[strings.ToLower(c)] != nil:
+			case Closest(c, classNames) != "":
+				errorf("%s: object class %s is not in the schema, did you mean %s?", f.where, c, Closest(c, classNames))
+			default:
+				errorf("%s: object class %s is not in the schema", f.where, c)
+			}
+		}
+	}
+	return fs
+}
+
+func containsString(l []string, s string) bool {
+	for _, x := range l {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestCheckSchema(t *testing.T) {
+	dir := testDirectory()
+	dir.Entries[""] = map[string][]string{"subschemaSubentry": {"cn=Subschema"}}
+	dir.Entries["cn=Subschema"] = map[string][]string{
+		"objectClass": {"top", "subschema"},
+		"attributeTypes": {
+			"( 2.5.4.41 NAME 'name' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15{32768} )",
+			"( 2.5.4.3 NAME ( 'cn' 'commonName' ) DESC 'RFC4519: common name(s)' SUP name )",
+			"( 2.16.840.1.113730.3.1.241 NAME 'displayName' SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+			"( 0.9.2342.19200300.100.1.3 NAME ( 'mail' 'rfc822Mailbox' ) SYNTAX 1.3.6.1.4.1.1466.115.121.1.26{256} )",
+			"( 0.9.2342.19200300.100.1.1 NAME ( 'uid' 'userid' ) SUP name )",
+			"( 2.5.4.0 NAME 'objectClass' SYNTAX 1.3.6.1.4.1.1466.115.121.1.38 )",
+			"( 1.2.840.113556.1.2.102 NAME 'memberOf' SYNTAX '1.3.6.1.4.1.1466.115.121.1.12' NO-USER-MODIFICATION )",
+		},
+		"objectClasses": {
+			"( 2.16.840.1.113730.3.2.2 NAME 'inetOrgPerson' SUP organizationalPerson STRUCTURAL MAY ( audio $ mail $ uid ) )",
+		},
+	}
+	ctn, err := ldap.DialURL(startFakeDirectory(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctn.Close()
+	s, err := ReadSchema(ctn, LDAPConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := s.Attributes["commonname"]; a == nil || a.Syntax != "1.3.6.1.4.1.1466.115.121.1.15" {
+		t.Errorf("cn = %+v, want the syntax inherited from name", a)
+	}
+	if a := s.Attributes["mail"]; a == nil || a.SingleValue || a.Syntax != "1.3.6.1.4.1.1466.115.121.1.26" {
+		t.Errorf("mail = %+v", a)
+	}
+
+	cfg := LDAPConfig{
+		UserFilter: "(&(objectClass=inetOrgPeron)(uid={{.UserName}}))",
+		Attributes: AttributeMap{Email: "email", Groups: "memberof"},
+		Groups:     GroupConfig{Strategies: []string{StrategyMemberOf}},
+	}
+	var out strings.Builder
+	fs := CheckSchema(s, cfg)
+	WriteFindings(&out, fs)
+	for _, want := range []string{
+		"error: email: attribute email is not in the schema, did you mean mail?",
+		"warning: groups: attribute memberof is spelled memberOf in the schema",
+		"error: user_filter: object class inetOrgPeron is not in the schema, did you mean inetOrgPerson?",
+		"info: display_name: displayName, Directory String, single-valued",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing finding %q in:\n%s", want, out.String())
+		}
+	}
+}
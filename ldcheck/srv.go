@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Resolver looks up DNS SRV records, *net.Resolver satisfies it.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// SRV URL schemes, with the service looked up and the scheme of the discovered servers
+var srvSchemes = map[string]struct{ Service, Scheme string }{
+	"ldap+srv":  {"ldap", "ldap"},
+	"ldaps+srv": {"ldaps", "ldaps"},
+	"gc+srv":    {"gc", "gc"}, // Active Directory global catalog
+}
+
+// ParseSRVURL recognizes ldap+srv://domain URLs, returning the SRV name to look up.
+func ParseSRVURL(addr string) (name, scheme string, ok bool, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", false, err
+	}
+	s, ok := srvSchemes[u.Scheme]
+	if !ok {
+		return "", "", false, nil
+	}
+	if u.Hostname() == "" || u.Port() != "" {
+		return "", "", true, fmt.Errorf("%s: want %s://domain, the port comes from the SRV records", addr, u.Scheme)
+	}
+	return "_" + s.Service + "._tcp." + u.Hostname(), s.Scheme, true, nil
+}
+
+// Discover expands the SRV URLs into the servers they designate, ordered by priority then weight.
+// Failed lookups are reported in the Error of their attempt.
+func (d *Dialer) Discover(urls []string) []ServerAttempt {
+	var found []ServerAttempt
+	for _, u := range urls {
+		name, scheme, isSRV, err := ParseSRVURL(u)
+		switch {
+		case err != nil:
+			found = append(found, ServerAttempt{URL: u, Error: err.Error()})
+			continue
+		case !isSRV:
+			found = append(found, ServerAttempt{URL: u})
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), d.DialTimeout)
+		_, addrs, err := d.Resolver.LookupSRV(ctx, "", "", name)
+		cancel()
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			err = fmt.Errorf("no SRV record for %s", name)
+		}
+		if err != nil {
+			found = append(found, ServerAttempt{URL: u, Error: err.Error()})
+			continue
+		}
+
+		sort.SliceStable(addrs, func(i, j int) bool {
+			if addrs[i].Priority != addrs[j].Priority {
+				return addrs[i].Priority < addrs[j].Priority
+			}
+			return addrs[i].Weight > addrs[j].Weight
+		})
+		n := len(found)
+		for _, a := range addrs {
+			target := strings.TrimSuffix(a.Target, ".")
+			if target == "" {
+				continue // "." means the service is decidedly not available
+			}
+			found = append(found, ServerAttempt{
+				URL: scheme + "://" + net.JoinHostPort(target, strconv.Itoa(int(a.Port))),
+				SRV: fmt.Sprintf("%s priority %d weight %d", name, a.Priority, a.Weight),
+			})
+		}
+		if len(found) == n {
+			found = append(found, ServerAttempt{URL: u, Error: fmt.Sprintf("no server in the SRV records of %s", name)})
+		}
+	}
+	return found
+}
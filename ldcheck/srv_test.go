@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers SRV queries from a table, standing in for DNS.
+type fakeResolver map[string][]*net.SRV
+
+func (r fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	addrs, ok := r[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, addrs, nil
+}
+
+func TestDiscover(t *testing.T) {
+	url := startFakeDirectory(t, testDirectory())
+	_, portstr, _ := strings.Cut(strings.TrimPrefix(url, "ldap://127.0.0.1"), ":")
+	port, _ := strconv.Atoi(portstr)
+
+	d, err := NewDialer(LDAPConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Resolver = fakeResolver{
+		"_ldap._tcp.example.com": {
+			{Target: "backup.example.com.", Port: 389, Priority: 10, Weight: 100},
+			{Target: "127.0.0.1.", Port: uint16(port), Priority: 0, Weight: 10},
+			{Target: "127.0.0.2.", Port: 1, Priority: 0, Weight: 50},
+		},
+	}
+
+	got := d.Discover([]string{"ldap+srv://example.com", "gc+srv://example.com", "ldaps://dc1.example.com"})
+	var urls []string
+	for _, at := range got {
+		urls = append(urls, at.URL)
+	}
+	want := []string{"ldap://127.0.0.2:1", "ldap://" + net.JoinHostPort("127.0.0.1", portstr), "ldap://backup.example.com:389",
+		"gc+srv://example.com", "ldaps://dc1.example.com"}
+	if strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Errorf("discovered %q, want %q", urls, want)
+	}
+	if got[3].Error != "no SRV record for _gc._tcp.example.com" {
+		t.Errorf("missing gc records: error %q", got[3].Error)
+	}
+
+	ctn, tried, err := d.DialServers([]string{"ldap+srv://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctn.Close()
+	if len(tried) != 2 || tried[0].Error == "" || tried[1].SRV != "_ldap._tcp.example.com priority 0 weight 10" {
+		t.Errorf("tried %+v", tried)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSConfig is the trust configuration used for ldaps and StartTLS connections.
+type TLSConfig struct {
+	CAFile             string   `toml:"ca_file"`     // PEM bundle replacing the system roots
+	ClientCert         string   `toml:"client_cert"` // PEM certificate for mutual TLS
+	ClientKey          string   `toml:"client_key"`
+	Pins               []string `toml:"pins"`        // sha256/<base64> digests of the SubjectPublicKeyInfo
+	MinVersion         string   `toml:"min_version"` // 1.0, 1.1, 1.2 (default) or 1.3
+	InsecureSkipVerify bool     `toml:"insecure_skip_verify"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// versionName is tls.VersionName, which needs Go 1.21.
+func versionName(v uint16) string {
+	if n, ok := tlsVersionNames[v]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%04X", v)
+}
+
+var ErrPinMismatch = errors.New("no certificate in the chain matches the configured pins")
+
+// Load returns the base TLS configuration. ServerName and MinVersion are set per connection.
+func (c TLSConfig) Load() (*tls.Config, error) {
+	tc := new(tls.Config)
+
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid min_version %q: want 1.0, 1.1, 1.2 or 1.3", c.MinVersion)
+		}
+		tc.MinVersion = v
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", c.CAFile)
+		}
+	}
+
+	switch {
+	case c.ClientCert != "" && c.ClientKey != "":
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	case c.ClientCert != "" || c.ClientKey != "":
+		return nil, errors.New("client_cert and client_key must be set together")
+	}
+
+	if len(c.Pins) > 0 {
+		pins := make(map[string]bool, len(c.Pins))
+		for _, p := range c.Pins {
+			d, ok := strings.CutPrefix(p, "sha256/")
+			if !ok {
+				return nil, fmt.Errorf("invalid pin %q: want sha256/<base64>", p)
+			}
+			d = strings.TrimPrefix(d, "/") // curl style sha256//
+			if raw, err := base64.StdEncoding.DecodeString(d); err != nil || len(raw) != sha256.Size {
+				return nil, fmt.Errorf("invalid pin %q: not a base64 SHA-256 digest", p)
+			}
+			pins[d] = true
+		}
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				if pins[SPKIPin(cert)] {
+					return nil
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+
+	tc.InsecureSkipVerify = c.InsecureSkipVerify
+
+	return tc, nil
+}
+
+// SPKIPin returns the base64 SHA-256 digest of the certificate public key, as used in pins.
+func SPKIPin(cert *x509.Certificate) string {
+	d := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(d[:])
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestTLSConfigLoad(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	url := "ldaps://" + srv.Listener.Addr().String()
+
+	dir := t.TempDir()
+	ca := writePEM(t, dir, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+	pin := "sha256/" + SPKIPin(srv.Certificate())
+	other := "sha256/" + strings.Repeat("A", 43) + "="
+	cert, key := clientCertificate(t, dir)
+
+	for _, c := range []struct {
+		name string
+		cfg  TLSConfig
+		err  error // from the handshake, nil when it succeeds
+	}{
+		{"system roots", TLSConfig{}, x509.UnknownAuthorityError{}},
+		{"ca_file", TLSConfig{CAFile: ca}, nil},
+		{"pin match", TLSConfig{CAFile: ca, Pins: []string{other, pin}}, nil},
+		{"curl style pin", TLSConfig{CAFile: ca, Pins: []string{"sha256//" + SPKIPin(srv.Certificate())}}, nil},
+		{"pin mismatch", TLSConfig{CAFile: ca, Pins: []string{other}}, ErrPinMismatch},
+		{"insecure", TLSConfig{InsecureSkipVerify: true}, nil},
+		{"insecure pin mismatch", TLSConfig{InsecureSkipVerify: true, Pins: []string{other}}, ErrPinMismatch},
+		{"client certificate", TLSConfig{CAFile: ca, ClientCert: cert, ClientKey: key}, nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tc, err := c.cfg.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.cfg.ClientCert != "" && len(tc.Certificates) != 1 {
+				t.Errorf("client certificate not loaded")
+			}
+
+			d := &Dialer{TLS: tc, DialTimeout: time.Second, TLSTimeout: time.Second}
+			ctn, err := d.DialURL(url)
+			if c.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				ctn.Close()
+				return
+			}
+
+			var lerr *ldap.Error
+			switch {
+			case err == nil:
+				ctn.Close()
+				t.Fatal("handshake succeeded")
+			case connectExit(err) != ExitTLS:
+				t.Errorf("exit code %d for %s, want %d", connectExit(err), err, ExitTLS)
+			case c.err == ErrPinMismatch && (!errors.As(err, &lerr) || !errors.Is(lerr.Err, ErrPinMismatch)):
+				t.Errorf("got %s, want %s", err, ErrPinMismatch)
+			}
+		})
+	}
+}
+
+func TestTLSConfigLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := writePEM(t, dir, "empty.pem", "PRIVATE KEY", []byte("not a certificate"))
+	cert, _ := clientCertificate(t, dir)
+
+	for _, c := range []struct {
+		cfg  TLSConfig
+		want string
+	}{
+		{TLSConfig{MinVersion: "1.4"}, "invalid min_version"},
+		{TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}, "reading CA bundle"},
+		{TLSConfig{CAFile: empty}, "no certificate found"},
+		{TLSConfig{ClientCert: cert}, "must be set together"},
+		{TLSConfig{ClientCert: cert, ClientKey: empty}, "loading client certificate"},
+		{TLSConfig{Pins: []string{"md5/AAAA"}}, "want sha256/<base64>"},
+		{TLSConfig{Pins: []string{"sha256/not base64"}}, "not a base64 SHA-256 digest"},
+		{TLSConfig{Pins: []string{"sha256/AAAA"}}, "not a base64 SHA-256 digest"},
+	} {
+		if _, err := c.cfg.Load(); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%+v: got error %v, want %q", c.cfg, err, c.want)
+		}
+	}
+}
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clientCertificate writes a self-signed certificate and its key, returning their paths.
+func clientCertificate(t *testing.T, dir string) (cert, key string) {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ldcheck"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client.key", "PRIVATE KEY", kder)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Handshake connects to the server and performs the TLS handshake (directly for ldaps, with StartTLS for ldap),
+// without verifying the certificate so the chain can be inspected even when it would be rejected.
+func (d *Dialer) Handshake(addr string) (Endpoint, tls.ConnectionState, error) {
+	ep, err := ParseURL(addr)
+	if err != nil {
+		return ep, tls.ConnectionState{}, err
+	}
+
+	tc := d.tlsConfig(ep.Host)
+	tc.InsecureSkipVerify = true
+	tc.VerifyConnection = nil
+	cs, err := d.handshake(ep, tc)
+	return ep, cs, err
+}
+
+// unreachableError marks failures to reach the server, as opposed to a rejected handshake.
+type unreachableError struct{ error }
+
+func (e unreachableError) Unwrap() error { return e.error }
+
+// handshake performs the TLS handshake within the dial and TLS timeouts.
+// Timeouts are reported as unreachable: the server accepted the connection but does not speak.
+func (d *Dialer) handshake(ep Endpoint, tc *tls.Config) (tls.ConnectionState, error) {
+	if ep.Scheme != "ldaps" && ep.Scheme != "ldap" {
+		return tls.ConnectionState{}, fmt.Errorf("no TLS on %s connections", ep.Scheme)
+	}
+	cn, err := net.DialTimeout(ep.Network, ep.Addr, d.DialTimeout)
+	if err != nil {
+		return tls.ConnectionState{}, unreachableError{err}
+	}
+	defer cn.Close()
+	dl := deadline(d.TLSTimeout)
+	cn.SetDeadline(dl)
+
+	var cs tls.ConnectionState
+	if ep.Scheme == "ldaps" {
+		tlsc := tls.Client(cn, tc)
+		if err = tlsc.Handshake(); err == nil {
+			cs = tlsc.ConnectionState()
+		}
+	} else {
+		ctn := ldap.NewConn(cn, false)
+		ctn.Start()
+		defer ctn.Close()
+		if err = ctn.StartTLS(tc); err == nil {
+			cs, _ = ctn.TLSConnectionState()
+		}
+	}
+
+	// go-ldap does not wrap the timeout of the StartTLS response
+	if err != nil && !dl.IsZero() && !time.Now().Before(dl) {
+		return cs, unreachableError{fmt.Errorf("no TLS handshake within %s: %w", d.TLSTimeout, err)}
+	}
+	return cs, err
+}
+
+// Verify checks the presented chain the same way the connection would, returning all the failures found.
+func (d *Dialer) Verify(host string, cs tls.ConnectionState) (chainErr, hostErr, pinErr error) {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no certificate presented"), nil, nil
+	}
+
+	opts := x509.VerifyOptions{Intermediates: x509.NewCertPool()}
+	if d.TLS != nil {
+		opts.Roots = d.TLS.RootCAs
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, chainErr = cs.PeerCertificates[0].Verify(opts)
+	hostErr = cs.PeerCertificates[0].VerifyHostname(host)
+	if d.TLS != nil && d.TLS.VerifyConnection != nil {
+		pinErr = d.TLS.VerifyConnection(cs)
+	}
+	return
+}
+
+// WriteTLSReport prints the negotiated parameters and the presented certificate chain.
+func (d *Dialer) WriteTLSReport(w io.Writer, addr string) error {
+	ep, cs, err := d.Handshake(addr)
+	if err != nil {
+		return fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+	}
+
+	fmt.Fprintln(w, "server:      ", ep.Addr)
+	fmt.Fprintln(w, "protocol:    ", versionName(cs.Version))
+	fmt.Fprintln(w, "cipher suite:", tls.CipherSuiteName(cs.CipherSuite))
+
+	now := time.Now()
+	for i, c := range cs.PeerCertificates {
+		fmt.Fprintf(w, "\ncertificate #%d\n", i)
+		fmt.Fprintln(w, "  subject:   ", c.Subject)
+		fmt.Fprintln(w, "  issuer:    ", c.Issuer)
+		if sans := certSANs(c); len(sans) > 0 {
+			fmt.Fprintln(w, "  SANs:      ", strings.Join(sans, ", "))
+		}
+		fmt.Fprintln(w, "  key:       ", KeyType(c))
+		fmt.Fprintln(w, "  not before:", c.NotBefore.Format(time.RFC3339))
+		fmt.Fprintln(w, "  not after: ", c.NotAfter.Format(time.RFC3339))
+		days := int(math.Floor(c.NotAfter.Sub(now).Hours() / 24))
+		switch {
+		case now.Before(c.NotBefore):
+			fmt.Fprintln(w, "  expiry:     NOT YET VALID")
+		case days < 0:
+			fmt.Fprintf(w, "  expiry:     EXPIRED %d days ago\n", -days)
+		default:
+			fmt.Fprintf(w, "  expiry:     in %d days\n", days)
+		}
+		fmt.Fprintln(w, "  SPKI pin:   sha256/"+SPKIPin(c))
+	}
+
+	chainErr, hostErr, pinErr := d.Verify(ep.Host, cs)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "chain:       ", verdict(chainErr))
+	fmt.Fprintln(w, "hostname:    ", ep.Host, verdict(hostErr))
+	if d.TLS != nil && d.TLS.VerifyConnection != nil {
+		fmt.Fprintln(w, "pins:        ", verdict(pinErr))
+	}
+	return nil
+}
+
+func verdict(err error) string {
+	if err != nil {
+		return "FAIL (" + err.Error() + ")"
+	}
+	return "ok"
+}
+
+func certSANs(c *x509.Certificate) []string {
+	sans := append([]string{}, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+func KeyType(c *x509.Certificate) string {
+	switch k := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d bits", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return c.PublicKeyAlgorithm.String()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTLSReport(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	good := TLSConfig{Pins: []string{"sha256/" + SPKIPin(srv.Certificate())}}
+	bad := TLSConfig{Pins: []string{"sha256/" + strings.Repeat("A", 43) + "="}}
+
+	for _, c := range []struct {
+		name  string
+		host  string
+		roots *x509.CertPool
+		pins  TLSConfig
+		want  []string // regular expressions matching lines of the report
+	}{
+		{"trusted", "127.0.0.1", roots, good, []string{
+			`^chain: +ok$`, `^hostname: +127\.0\.0\.1 ok$`, `^pins: +ok$`, `^  expiry: +in \d+ days$`,
+			`^  SANs: +example\.com, .*127\.0\.0\.1`, `^  SPKI pin: +sha256/` + regexp.QuoteMeta(SPKIPin(srv.Certificate())) + `$`,
+		}},
+		{"unknown authority", "127.0.0.1", nil, TLSConfig{}, []string{`^chain: +FAIL \(.*unknown authority\)$`, `^hostname: +127\.0\.0\.1 ok$`}},
+		{"wrong host", "localhost", roots, bad, []string{
+			`^chain: +ok$`, `^hostname: +localhost FAIL \(.*localhost.*\)$`, `^pins: +FAIL \(` + ErrPinMismatch.Error() + `\)$`,
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tc, err := c.pins.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.RootCAs = c.roots
+			d := &Dialer{TLS: tc, DialTimeout: time.Second, TLSTimeout: time.Second}
+
+			var out strings.Builder
+			if err := d.WriteTLSReport(&out, "ldaps://"+net.JoinHostPort(c.host, port)); err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range c.want {
+				if !regexp.MustCompile("(?m)" + w).MatchString(out.String()) {
+					t.Errorf("missing %s in:\n%s", w, out.String())
+				}
+			}
+			if c.pins.Pins == nil && strings.Contains(out.String(), "pins:") {
+				t.Errorf("pins verdict without pins configured:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestWriteTLSReportExpiry(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		notBefore, notAfter time.Time
+		want                string
+	}{
+		{now.Add(-48 * time.Hour), now.Add(-(10*24 + 1) * time.Hour), "EXPIRED 11 days ago"},
+		{now.Add(24 * time.Hour), now.Add(48 * time.Hour), "NOT YET VALID"},
+		{now.Add(-time.Hour), now.Add((30*24 + 1) * time.Hour), "in 30 days"},
+	} {
+		srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+		srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+		srv.TLS = &tls.Config{Certificates: []tls.Certificate{serverCertificate(t, c.notBefore, c.notAfter)}}
+		srv.StartTLS()
+
+		var out strings.Builder
+		d := &Dialer{DialTimeout: time.Second, TLSTimeout: time.Second}
+		err := d.WriteTLSReport(&out, "ldaps://"+srv.Listener.Addr().String())
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "expiry:     "+c.want+"\n") || !strings.Contains(out.String(), "chain:        FAIL") {
+			t.Errorf("want expiry %q and a failed chain in:\n%s", c.want, out.String())
+		}
+	}
+}
+
+func serverCertificate(t *testing.T, notBefore, notAfter time.Time) tls.Certificate {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ldap.example.com"},
+		DNSNames:     []string{"ldap.example.com"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: k}
+}
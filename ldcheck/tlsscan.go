@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+var scannedVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+type scanKey struct{ Version, Suite uint16 }
+
+// TLSScan records which protocol version and cipher suite combinations the server accepts.
+//
+// Go does not allow choosing the TLS 1.3 suites, so only the one negotiated is known for that version.
+type TLSScan struct {
+	Suites   []*tls.CipherSuite
+	Accepted map[scanKey]bool
+}
+
+// ScanTLS attempts one handshake per version and cipher suite supported by crypto/tls.
+func (d *Dialer) ScanTLS(addr string) (*TLSScan, error) {
+	ep, err := ParseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := &TLSScan{
+		Suites:   append(tls.CipherSuites(), tls.InsecureCipherSuites()...),
+		Accepted: make(map[scanKey]bool),
+	}
+
+	try := func(version uint16, suites []uint16) error {
+		tc := d.tlsConfig(ep.Host)
+		tc.InsecureSkipVerify = true
+		tc.VerifyConnection = nil
+		tc.MinVersion, tc.MaxVersion = version, version
+		tc.CipherSuites = suites
+
+		cs, err := d.handshake(ep, tc)
+		var unreachable unreachableError
+		switch {
+		case errors.As(err, &unreachable):
+			return err
+		case err == nil:
+			scan.Accepted[scanKey{cs.Version, cs.CipherSuite}] = true
+		}
+		return nil
+	}
+
+	for _, v := range scannedVersions {
+		if v == tls.VersionTLS13 {
+			if err := try(v, nil); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, s := range scan.Suites {
+			if !supportsVersion(s, v) {
+				continue
+			}
+			if err := try(v, []uint16{s.ID}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return scan, nil
+}
+
+func supportsVersion(s *tls.CipherSuite, v uint16) bool {
+	for _, sv := range s.SupportedVersions {
+		if sv == v {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteMatrix prints one row per cipher suite and one column per protocol version.
+func (scan *TLSScan) WriteMatrix(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "cipher suite")
+	for _, v := range scannedVersions {
+		fmt.Fprint(tw, "\t", versionName(v))
+	}
+	fmt.Fprintln(tw)
+
+	for _, s := range scan.Suites {
+		fmt.Fprint(tw, s.Name)
+		if s.Insecure {
+			fmt.Fprint(tw, " (insecure)")
+		}
+		for _, v := range scannedVersions {
+			switch {
+			case !supportsVersion(s, v):
+				fmt.Fprint(tw, "\t")
+			case scan.Accepted[scanKey{v, s.ID}]:
+				fmt.Fprint(tw, "\tyes")
+			case v == tls.VersionTLS13:
+				fmt.Fprint(tw, "\t?")
+			default:
+				fmt.Fprint(tw, "\tno")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\n? TLS 1.3 suites cannot be selected: only the one preferred by the server is tested")
+
+	for _, v := range scannedVersions {
+		if scan.AcceptsVersion(v) && v < tls.VersionTLS12 {
+			fmt.Fprintf(w, "WARNING: server accepts deprecated %s\n", versionName(v))
+		}
+	}
+	for _, s := range scan.Suites {
+		for _, v := range scannedVersions {
+			if s.Insecure && scan.Accepted[scanKey{v, s.ID}] {
+				fmt.Fprintf(w, "WARNING: server accepts insecure suite %s with %s\n", s.Name, versionName(v))
+			}
+		}
+	}
+}
+
+func (scan *TLSScan) AcceptsVersion(v uint16) bool {
+	for k := range scan.Accepted {
+		if k.Version == v {
+			return true
+		}
+	}
+	return false
+}
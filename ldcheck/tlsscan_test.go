@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScanTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.TLS = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	d := &Dialer{}
+	scan, err := d.ScanTLS("ldaps://" + srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		version, suite uint16
+		want           bool
+	}{
+		{tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, true},
+		{tls.VersionTLS12, tls.TLS_RSA_WITH_AES_128_CBC_SHA, true},
+		{tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, false},
+		{tls.VersionTLS10, tls.TLS_RSA_WITH_AES_128_CBC_SHA, false},
+	} {
+		if got := scan.Accepted[scanKey{c.version, c.suite}]; got != c.want {
+			t.Errorf("%s with %s: accepted = %t, want %t", versionName(c.version), tls.CipherSuiteName(c.suite), got, c.want)
+		}
+	}
+	if scan.AcceptsVersion(tls.VersionTLS13) {
+		t.Error("TLS 1.3 reported as accepted")
+	}
+}
+
+func TestScanTLSUnreachable(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	addr := srv.Listener.Addr().String()
+	srv.Close()
+
+	d := &Dialer{}
+	if _, err := d.ScanTLS("ldaps://" + addr); err == nil {
+		t.Error("scan of closed port succeeded")
+	}
+}
+
+func TestTLSTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			cn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer cn.Close() // accepted, never answered
+		}
+	}()
+
+	d := &Dialer{DialTimeout: time.Second, TLSTimeout: 100 * time.Millisecond, OperationTimeout: time.Second}
+	for _, scheme := range []string{"ldaps", "ldap"} {
+		url := scheme + "://" + l.Addr().String()
+		start := time.Now()
+		if _, err := d.ScanTLS(url); err == nil {
+			t.Errorf("%s: scan of a silent server succeeded", scheme)
+		}
+		if err := d.WriteTLSReport(io.Discard, url); err == nil || connectExit(err) != ExitNetwork {
+			t.Errorf("%s: report on a silent server: %v, want a network failure", scheme, err)
+		}
+		if el := time.Since(start); el > time.Second {
+			t.Errorf("%s: gave up after %s, want the TLS timeout", scheme, el)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	ErrUserNotFound  = errors.New("no entry matches the user filter")
+	ErrMultipleUsers = errors.New("more than one entry matches the user filter")
+)
+
+// RenderUserFilter fills the user filter template with the user name, escaped for a filter (RFC 4515).
+func RenderUserFilter(pattern, name string) (string, error) {
+	filter, err := renderPattern("user_filter", pattern, name, ldap.EscapeFilter)
+	if err != nil {
+		return "", fmt.Errorf("invalid user filter: %w", err)
+	}
+	return filter, nil
+}
+
+// SearchUserDN binds with the service account and looks for the single entry matching the user filter.
+// An empty bind DN performs the search anonymously.
+func SearchUserDN(ctn *ldap.Conn, cfg LDAPConfig, filter string) (string, error) {
+	if cfg.BindDN != "" {
+		if err := ctn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+			return "", fmt.Errorf("service account %s denied: %w", cfg.BindDN, err)
+		}
+	}
+
+	userq := ldap.NewSearchRequest(
+		cfg.UserSearchBase,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter,
+		[]string{"1.1"}, // only the DN is needed
+		nil,
+	)
+	sr, err := ctn.Search(userq)
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded):
+		return "", ErrMultipleUsers
+	case err != nil:
+		return "", fmt.Errorf("searching user under %s: %w", cfg.UserSearchBase, err)
+	case len(sr.Entries) == 0:
+		return "", ErrUserNotFound
+	case len(sr.Entries) > 1:
+		return "", ErrMultipleUsers
+	}
+
+	return sr.Entries[0].DN, nil
+}